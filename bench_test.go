@@ -31,6 +31,16 @@ func BenchmarkDiscard(b *testing.B) {
 	}
 }
 
+func BenchmarkSampler(b *testing.B) {
+	lg := New()
+	lg.SetHandler(DiscardHandler())
+	lg.SetSampler(CountSampler(time.Second, 100, 100))
+
+	for i := 0; i < b.N; i++ {
+		lg.Info("test message")
+	}
+}
+
 func BenchmarkCallerFileHandler(b *testing.B) {
 	lg := New()
 	lg.SetHandler(CallerFileHandler(DiscardHandler()))
@@ -0,0 +1,17 @@
+package log
+
+// LogBuildInfo logs a record at LvlInfo to l describing the binary, from
+// the build information the Go toolchain embeds in it, so every log
+// stream identifies the program and revision that wrote it. Call it once
+// the handlers are set up:
+//
+//     log.Root().SetHandler(h)
+//     log.LogBuildInfo(log.Root())
+//
+// The record has the Go version under "go", and the path and version of
+// the main module under "path" and "version". Binaries built with Go 1.18
+// or later from a version control checkout also get "vcs.revision",
+// "vcs.time" and "vcs.modified".
+func LogBuildInfo(l Logger) {
+	l.Info("build info", buildInfo()...)
+}
@@ -0,0 +1,16 @@
+// +build !go1.18
+
+package log
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+func buildInfo() []interface{} {
+	ctx := []interface{}{"go", runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		ctx = append(ctx, "path", bi.Main.Path, "version", bi.Main.Version)
+	}
+	return ctx
+}
@@ -0,0 +1,24 @@
+// +build go1.18
+
+package log
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+func buildInfo() []interface{} {
+	ctx := []interface{}{"go", runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ctx
+	}
+	ctx = append(ctx, "path", bi.Main.Path, "version", bi.Main.Version)
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision", "vcs.time", "vcs.modified":
+			ctx = append(ctx, s.Key, s.Value)
+		}
+	}
+	return ctx
+}
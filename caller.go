@@ -0,0 +1,40 @@
+package log
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-stack/stack"
+)
+
+// callerStrings caches the "file:line" rendering of call sites by program
+// counter. The map is replaced, never modified, when a call site is
+// added, so lookups need neither a lock nor an allocation; a program has
+// few enough call sites that copying the map is cheap.
+var (
+	callerMu      sync.Mutex
+	callerStrings atomic.Value // map[uintptr]string
+)
+
+// callerString returns fmt.Sprint(c), formatting each call site only the
+// first time it is seen.
+func callerString(c stack.Call) string {
+	pc := c.Frame().PC
+	m, _ := callerStrings.Load().(map[uintptr]string)
+	if s, ok := m[pc]; ok {
+		return s
+	}
+
+	s := fmt.Sprint(c)
+	callerMu.Lock()
+	defer callerMu.Unlock()
+	old, _ := callerStrings.Load().(map[uintptr]string)
+	m = make(map[uintptr]string, len(old)+1)
+	for k, v := range old {
+		m[k] = v
+	}
+	m[pc] = s
+	callerStrings.Store(m)
+	return s
+}
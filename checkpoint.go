@@ -0,0 +1,61 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// WriteCheckpoint stores offset in the file at path, for a program
+// shipping records from a log file to resume where it left off after a
+// restart. The file is replaced atomically, so a crash leaves either the
+// old or the new offset. Store the offset of a record once it is shipped:
+//
+//     off, err := log.ReadCheckpoint("app.log.offset")
+//     f.Seek(off, io.SeekStart)
+//     d := log.NewDecoder(f)
+//     d.Follow(true)
+//     for {
+//         r, err := d.Decode()
+//         ...
+//         ship(r)
+//         log.WriteCheckpoint("app.log.offset", off+d.Offset())
+//     }
+//
+// If the log file is rotated or truncated, the stored offset may lie past
+// its end; start from 0 when it is larger than the file.
+func WriteCheckpoint(path string, offset int64) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(strconv.FormatInt(offset, 10) + "\n")
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// ReadCheckpoint returns the offset stored in the file at path by
+// WriteCheckpoint, or 0 if the file does not exist.
+func ReadCheckpoint(path string) (int64, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
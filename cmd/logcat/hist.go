@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/semihalev/log"
+)
+
+const (
+	histBarWidth = 40
+	histMaxEmpty = 10
+	histMaxRows  = 1000
+)
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// histogram is a log.Handler counting records per level in time buckets
+// of a fixed size. Records without a time are ignored.
+type histogram struct {
+	mu     sync.Mutex
+	bucket time.Duration
+	counts map[int64]*[log.LvlDebug + 1]int
+}
+
+func newHistogram(bucket time.Duration) *histogram {
+	return &histogram{bucket: bucket, counts: make(map[int64]*[log.LvlDebug + 1]int)}
+}
+
+func (h *histogram) Log(r *log.Record) error {
+	if r.Time.IsZero() || r.Lvl < log.LvlCrit || r.Lvl > log.LvlDebug {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	k := r.Time.Truncate(h.bucket).UnixNano()
+	c := h.counts[k]
+	if c == nil {
+		c = new([log.LvlDebug + 1]int)
+		h.counts[k] = c
+	}
+	c[r.Lvl]++
+	return nil
+}
+
+// render writes one row per bucket, from the first to the last bucket
+// holding records, with the count of each level and a bar scaled to the
+// busiest bucket. Runs of more than histMaxEmpty empty buckets are
+// collapsed into one row, and only the last histMaxRows rows are written.
+// The table is followed by a sparkline per level, scaled to the busiest
+// bucket of that level, to make bursts of errors stand out.
+func (h *histogram) render(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.counts) == 0 {
+		return nil
+	}
+
+	keys := make([]int64, 0, len(h.counts))
+	for k := range h.counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	var rows []histRow
+	for i, k := range keys {
+		if i > 0 {
+			empty := (k-keys[i-1])/int64(h.bucket) - 1
+			if empty > histMaxEmpty {
+				rows = append(rows, histRow{empty: empty})
+			} else {
+				for j := int64(1); j <= empty; j++ {
+					rows = append(rows, histRow{start: time.Unix(0, keys[i-1]+j*int64(h.bucket))})
+				}
+			}
+		}
+		rows = append(rows, histRow{start: time.Unix(0, k), counts: *h.counts[k]})
+	}
+	omitted := 0
+	if len(rows) > histMaxRows {
+		omitted = len(rows) - histMaxRows
+		rows = rows[omitted:]
+	}
+
+	var maxTotal int
+	var maxLvl [log.LvlDebug + 1]int
+	for _, row := range rows {
+		total := 0
+		for lvl, n := range row.counts {
+			total += n
+			if n > maxLvl[lvl] {
+				maxLvl[lvl] = n
+			}
+		}
+		if total > maxTotal {
+			maxTotal = total
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	if omitted > 0 {
+		fmt.Fprintf(bw, "(%d earlier rows omitted)\n", omitted)
+	}
+	fmt.Fprintf(bw, "%-20s", "time")
+	for lvl := log.LvlCrit; lvl <= log.LvlDebug; lvl++ {
+		fmt.Fprintf(bw, " %6s", lvl)
+	}
+	bw.WriteString("\n")
+	for _, row := range rows {
+		if row.empty > 0 {
+			fmt.Fprintf(bw, "%-20s (%d empty buckets)\n", "...", row.empty)
+			continue
+		}
+		fmt.Fprintf(bw, "%-20s", row.start.UTC().Format(time.RFC3339))
+		total := 0
+		for _, n := range row.counts {
+			fmt.Fprintf(bw, " %6d", n)
+			total += n
+		}
+		bw.WriteString("  ")
+		bw.WriteString(strings.Repeat("#", scale(total, maxTotal, histBarWidth)))
+		bw.WriteString("\n")
+	}
+
+	bw.WriteString("\n")
+	for lvl := log.LvlCrit; lvl <= log.LvlDebug; lvl++ {
+		fmt.Fprintf(bw, "%-4s ", lvl)
+		for _, row := range rows {
+			if row.counts[lvl] == 0 {
+				bw.WriteRune(' ')
+				continue
+			}
+			bw.WriteRune(sparks[(row.counts[lvl]*len(sparks)-1)/maxLvl[lvl]])
+		}
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
+
+// histRow is a row of the histogram, a bucket or, if empty is not zero,
+// a collapsed run of that many empty buckets.
+type histRow struct {
+	start  time.Time
+	counts [log.LvlDebug + 1]int
+	empty  int64
+}
+
+// scale maps n in [0, max] onto [0, width], rounding non-zero counts up so
+// that they stay visible.
+func scale(n, max, width int) int {
+	if n == 0 || max == 0 {
+		return 0
+	}
+	return (n*width + max - 1) / max
+}
@@ -0,0 +1,188 @@
+// Command logcat reads JSON log files, as written with log.JSONFormat, zap
+// or zerolog, as well as RFC 5424 syslog files, and renders them in a
+// human readable format. It reads from standard input when no files are
+// given.
+//
+//     logcat -lvl warn -since 2020-04-27T15:00:00Z app.log
+//
+// With -hist it prints the number of records per level in time buckets of
+// the given size instead, as a table with bars followed by a sparkline
+// per level, for a quick look at the shape of the traffic:
+//
+//     tail -f app.log | logcat -hist 1m
+//
+// The histogram is printed when the input ends, or when logcat is
+// interrupted while following a live stream.
+//
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/mattn/go-colorable"
+	isatty "github.com/mattn/go-isatty"
+	"github.com/semihalev/log"
+)
+
+type options struct {
+	format string
+	lvl    log.Lvl
+	since  time.Time
+	until  time.Time
+	skip   bool
+	hist   *histogram
+}
+
+func main() {
+	var (
+		format = flag.String("format", "", "output format: terminal, logfmt or json (default terminal on a tty, logfmt otherwise)")
+		lvl    = flag.String("lvl", "debug", "most verbose level to print")
+		since  = flag.String("since", "", "only print records at or after this RFC3339 time")
+		until  = flag.String("until", "", "only print records before this RFC3339 time")
+		skip   = flag.Bool("skip", false, "silently skip damaged lines, recovering records after garbage")
+		hist   = flag.Duration("hist", 0, "print a histogram of record counts per level in buckets of this size instead of the records")
+	)
+	flag.Parse()
+
+	opts, err := parseOptions(*format, *lvl, *since, *until)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "logcat:", err)
+		os.Exit(2)
+	}
+	opts.skip = *skip
+	if *hist > 0 {
+		opts.hist = newHistogram(*hist)
+	}
+
+	var out io.Writer = os.Stdout
+	if opts.format == "" {
+		opts.format = "logfmt"
+		if isatty.IsTerminal(os.Stdout.Fd()) {
+			opts.format = "terminal"
+			out = colorable.NewColorableStdout()
+		}
+	}
+
+	files := flag.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	if opts.hist != nil {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		go func() {
+			<-sig
+			opts.hist.render(out)
+			os.Exit(130)
+		}()
+	}
+
+	status := 0
+	for _, name := range files {
+		if err := catFile(name, out, opts); err != nil {
+			fmt.Fprintln(os.Stderr, "logcat:", err)
+			status = 1
+		}
+	}
+	if opts.hist != nil {
+		if err := opts.hist.render(out); err != nil {
+			fmt.Fprintln(os.Stderr, "logcat:", err)
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
+
+func parseOptions(format, lvl, since, until string) (opts options, err error) {
+	switch format {
+	case "", "terminal", "logfmt", "json":
+		opts.format = format
+	default:
+		return opts, fmt.Errorf("unknown format %q", format)
+	}
+
+	if opts.lvl, err = log.LvlFromString(lvl); err != nil {
+		return opts, err
+	}
+	if since != "" {
+		if opts.since, err = time.Parse(time.RFC3339, since); err != nil {
+			return opts, err
+		}
+	}
+	if until != "" {
+		if opts.until, err = time.Parse(time.RFC3339, until); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+func catFile(name string, out io.Writer, opts options) error {
+	if name == "-" {
+		return cat(os.Stdin, out, opts)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := cat(f, out, opts); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// cat decodes all records of rd and writes the ones matching opts to out,
+// or counts them in opts.hist if it is set.
+// Lines that fail to decode are reported on stderr, unless opts.skip is
+// set, and skipped.
+func cat(rd io.Reader, out io.Writer, opts options) error {
+	var fmtr log.Format
+	switch opts.format {
+	case "terminal":
+		fmtr = log.TerminalFormat()
+	case "json":
+		fmtr = log.JSONFormat()
+	default:
+		fmtr = log.LogfmtFormat()
+	}
+
+	sink := log.StreamHandler(out, fmtr)
+	if opts.hist != nil {
+		sink = opts.hist
+	}
+
+	h := log.LvlFilterHandler(opts.lvl, log.FilterHandler(func(r *log.Record) bool {
+		if !opts.since.IsZero() && r.Time.Before(opts.since) {
+			return false
+		}
+		return opts.until.IsZero() || r.Time.Before(opts.until)
+	}, sink))
+
+	dec := log.NewDecoder(rd)
+	dec.SkipInvalid(opts.skip)
+	for {
+		r, err := dec.Decode()
+		if err == io.EOF {
+			return nil
+		}
+		if derr, ok := err.(*log.DecodeError); ok {
+			fmt.Fprintln(os.Stderr, "logcat:", derr)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := h.Log(r); err != nil {
+			return err
+		}
+	}
+}
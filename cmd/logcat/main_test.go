@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/semihalev/log"
+)
+
+func TestCat(t *testing.T) {
+	t.Parallel()
+
+	in := strings.Join([]string{
+		`{"t":"2020-04-27T15:00:00+0000","lvl":"dbug","msg":"debug"}`,
+		`{"t":"2020-04-27T15:00:01+0000","lvl":"warn","msg":"early"}`,
+		`{"t":"2020-04-27T15:00:02+0000","lvl":"eror","msg":"failed","x":1}`,
+		`{"t":"2020-04-27T15:00:03+0000","lvl":"crit","msg":"late"}`,
+	}, "\n")
+
+	opts, err := parseOptions("logfmt", "warn", "2020-04-27T15:00:01Z", "2020-04-27T15:00:03Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := cat(strings.NewReader(in), &out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "t=2020-04-27T15:00:01+0000 lvl=warn msg=early\n" +
+		"t=2020-04-27T15:00:02+0000 lvl=eror msg=failed x=1\n"
+	if out.String() != expected {
+		t.Fatalf("Got %q, expected %q", out.String(), expected)
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	t.Parallel()
+
+	for _, args := range [][4]string{
+		{"xml", "info", "", ""},
+		{"json", "loud", "", ""},
+		{"json", "info", "yesterday", ""},
+	} {
+		if _, err := parseOptions(args[0], args[1], args[2], args[3]); err == nil {
+			t.Fatalf("Expected error for options %v", args)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	t.Parallel()
+
+	in := strings.Join([]string{
+		`{"t":"2020-04-27T15:00:10+0000","lvl":"info","msg":"a"}`,
+		`{"t":"2020-04-27T15:00:20+0000","lvl":"info","msg":"b"}`,
+		`{"t":"2020-04-27T15:00:30+0000","lvl":"dbug","msg":"c"}`,
+		`{"t":"2020-04-27T15:02:05+0000","lvl":"eror","msg":"d"}`,
+		`{"t":"2020-04-27T15:02:06+0000","lvl":"info","msg":"e"}`,
+	}, "\n")
+
+	opts, err := parseOptions("logfmt", "info", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.hist = newHistogram(time.Minute)
+
+	var out bytes.Buffer
+	if err := cat(strings.NewReader(in), &out, opts); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("Expected no records, got %q", out.String())
+	}
+	if err := opts.hist.render(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "time                   crit   eror   warn   info   dbug\n" +
+		"2020-04-27T15:00:00Z      0      0      0      2      0  " + strings.Repeat("#", 40) + "\n" +
+		"2020-04-27T15:01:00Z      0      0      0      0      0  \n" +
+		"2020-04-27T15:02:00Z      0      1      0      1      0  " + strings.Repeat("#", 40) + "\n" +
+		"\n" +
+		"crit    \n" +
+		"eror   █\n" +
+		"warn    \n" +
+		"info █ ▄\n" +
+		"dbug    \n"
+	if out.String() != expected {
+		t.Fatalf("Got:\n%s\nExpected:\n%s", out.String(), expected)
+	}
+}
+
+func TestHistogramOutlier(t *testing.T) {
+	t.Parallel()
+
+	h := newHistogram(time.Second)
+	h.Log(&log.Record{Time: time.Unix(0, 0), Lvl: log.LvlInfo})
+	h.Log(&log.Record{Time: time.Date(2020, 4, 27, 15, 0, 0, 0, time.UTC), Lvl: log.LvlInfo})
+
+	var out bytes.Buffer
+	if err := h.render(&out); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(out.String(), "\n"); n != 10 {
+		t.Fatalf("Expected empty buckets to be collapsed, got %d lines:\n%s", n, out.String())
+	}
+	if !strings.Contains(out.String(), "(1587999599 empty buckets)") {
+		t.Fatalf("Expected collapsed row, got:\n%s", out.String())
+	}
+}
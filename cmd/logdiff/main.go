@@ -0,0 +1,217 @@
+// Command logdiff compares two log captures, such as the logs of a service
+// before and after a deploy, and reports how their records differ. Both
+// files are read like logcat reads them: JSON lines as written with
+// log.JSONFormat, zap or zerolog, and RFC 5424 syslog lines.
+//
+//     logdiff -lvl warn before.log after.log
+//
+// Records are grouped by level and message. logdiff lists the messages
+// that only appear in one of the files, most frequent first, and those
+// whose share of all records changed by at least the -ratio factor. It
+// then lists context keys that appeared or disappeared, or whose number
+// of distinct values changed by that factor, which shows up new ids
+// leaking into a key or a key losing its variety. Values beyond 10000
+// distinct ones per key are not counted.
+//
+// The exit status is 1 if any differences were found and 2 on errors.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/semihalev/log"
+)
+
+const maxDistinct = 10000
+
+func main() {
+	var (
+		lvl   = flag.String("lvl", "debug", "most verbose level to compare")
+		ratio = flag.Float64("ratio", 2, "report messages and keys whose rate or cardinality changed by this factor")
+	)
+	flag.Parse()
+	if flag.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: logdiff [flags] before after")
+		os.Exit(2)
+	}
+	maxLvl, err := log.LvlFromString(*lvl)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "logdiff:", err)
+		os.Exit(2)
+	}
+
+	var sums [2]*summary
+	for i, name := range flag.Args() {
+		if sums[i], err = summarizeFile(name, maxLvl); err != nil {
+			fmt.Fprintln(os.Stderr, "logdiff:", err)
+			os.Exit(2)
+		}
+	}
+
+	if diff(os.Stdout, sums[0], sums[1], *ratio) {
+		os.Exit(1)
+	}
+}
+
+// msgKey identifies a kind of record.
+type msgKey struct {
+	lvl log.Lvl
+	msg string
+}
+
+// summary holds the distribution of the records of one capture.
+type summary struct {
+	total  int
+	msgs   map[msgKey]int
+	values map[string]map[string]struct{}
+}
+
+func summarizeFile(name string, maxLvl log.Lvl) (*summary, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s, err := summarize(f, maxLvl)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return s, nil
+}
+
+// summarize counts the records of rd at maxLvl or more severe. Lines that
+// are not records are skipped.
+func summarize(rd io.Reader, maxLvl log.Lvl) (*summary, error) {
+	s := &summary{
+		msgs:   make(map[msgKey]int),
+		values: make(map[string]map[string]struct{}),
+	}
+	dec := log.NewDecoder(rd)
+	dec.SkipInvalid(true)
+	for {
+		r, err := dec.Decode()
+		if err == io.EOF {
+			return s, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if r.Lvl > maxLvl {
+			continue
+		}
+
+		s.total++
+		s.msgs[msgKey{r.Lvl, r.Msg}]++
+		for i := 0; i+1 < len(r.Ctx); i += 2 {
+			k := fmt.Sprint(r.Ctx[i])
+			vals := s.values[k]
+			if vals == nil {
+				vals = make(map[string]struct{})
+				s.values[k] = vals
+			}
+			if len(vals) < maxDistinct {
+				vals[fmt.Sprint(r.Ctx[i+1])] = struct{}{}
+			}
+		}
+	}
+}
+
+// rate returns the share of all records that n represents.
+func (s *summary) rate(n int) float64 {
+	if s.total == 0 {
+		return 0
+	}
+	return float64(n) / float64(s.total)
+}
+
+// diff writes the differences between a and b to w and reports whether
+// there were any.
+func diff(w io.Writer, a, b *summary, ratio float64) bool {
+	var added, removed, changed []msgKey
+	for k, n := range b.msgs {
+		switch m := a.msgs[k]; {
+		case m == 0:
+			added = append(added, k)
+		case changedBy(a.rate(m), b.rate(n), ratio):
+			changed = append(changed, k)
+		}
+	}
+	for k := range a.msgs {
+		if b.msgs[k] == 0 {
+			removed = append(removed, k)
+		}
+	}
+
+	var keys []string
+	for k, vals := range b.values {
+		if changedBy(float64(len(a.values[k])), float64(len(vals)), ratio) {
+			keys = append(keys, k)
+		}
+	}
+	for k := range a.values {
+		if b.values[k] == nil {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "records: %d -> %d\n", a.total, b.total)
+	writeMsgs(w, "new messages", added, b.msgs, func(k msgKey) string {
+		return fmt.Sprint(b.msgs[k])
+	})
+	writeMsgs(w, "gone messages", removed, a.msgs, func(k msgKey) string {
+		return fmt.Sprint(a.msgs[k])
+	})
+	writeMsgs(w, "changed messages", changed, b.msgs, func(k msgKey) string {
+		return fmt.Sprintf("%d -> %d", a.msgs[k], b.msgs[k])
+	})
+	if len(keys) > 0 {
+		fmt.Fprintf(w, "\nchanged keys (distinct values):\n")
+		for _, k := range keys {
+			fmt.Fprintf(w, "  %-24s %s -> %s\n", k, cardinality(a.values[k]), cardinality(b.values[k]))
+		}
+	}
+
+	return len(added)+len(removed)+len(changed)+len(keys) > 0
+}
+
+// writeMsgs writes a section listing msgs, most frequent in counts first.
+func writeMsgs(w io.Writer, title string, msgs []msgKey, counts map[msgKey]int, count func(msgKey) string) {
+	if len(msgs) == 0 {
+		return
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		if counts[msgs[i]] != counts[msgs[j]] {
+			return counts[msgs[i]] > counts[msgs[j]]
+		}
+		if msgs[i].lvl != msgs[j].lvl {
+			return msgs[i].lvl < msgs[j].lvl
+		}
+		return msgs[i].msg < msgs[j].msg
+	})
+	fmt.Fprintf(w, "\n%s:\n", title)
+	for _, k := range msgs {
+		fmt.Fprintf(w, "  %s %-40q %s\n", k.lvl, k.msg, count(k))
+	}
+}
+
+// changedBy reports whether a and b differ by at least the given factor.
+// A change from or to zero always counts.
+func changedBy(a, b, ratio float64) bool {
+	if a == 0 || b == 0 {
+		return a != b
+	}
+	return a >= b*ratio || b >= a*ratio
+}
+
+func cardinality(vals map[string]struct{}) string {
+	if len(vals) >= maxDistinct {
+		return fmt.Sprintf("%d+", maxDistinct)
+	}
+	return fmt.Sprint(len(vals))
+}
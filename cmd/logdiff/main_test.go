@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/semihalev/log"
+)
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+
+	var before, after []string
+	for i := 0; i < 4; i++ {
+		before = append(before,
+			fmt.Sprintf(`{"lvl":"info","msg":"request","user":"u%d","code":200}`, i%2),
+			`{"lvl":"warn","msg":"retrying"}`)
+		after = append(after,
+			fmt.Sprintf(`{"lvl":"info","msg":"request","user":"u%d","code":200}`, i),
+			`{"lvl":"dbug","msg":"verbose"}`)
+	}
+	after = append(after, `{"lvl":"eror","msg":"db timeout","code":500}`, "garbage")
+
+	a, err := summarize(strings.NewReader(strings.Join(before, "\n")), log.LvlInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := summarize(strings.NewReader(strings.Join(after, "\n")), log.LvlInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if !diff(&out, a, b, 2) {
+		t.Fatal("Expected differences")
+	}
+	for _, want := range []string{
+		"records: 8 -> 5\n",
+		"new messages:\n  eror \"db timeout\"",
+		"gone messages:\n  warn \"retrying\"",
+		"changed keys (distinct values):\n  code                     1 -> 2\n  user                     2 -> 4\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("Expected %q in output:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "verbose") || strings.Contains(out.String(), "changed messages") {
+		t.Fatalf("Unexpected differences:\n%s", out.String())
+	}
+
+	out.Reset()
+	if diff(&out, a, a, 2) {
+		t.Fatalf("Expected no differences, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,227 @@
+// Command loggen generates strongly-typed logging methods from a schema of
+// events. It is meant to be invoked through go:generate:
+//
+//     //go:generate loggen -schema events.json -o events_log.go -pkg app
+//
+// The schema is a JSON document listing the events to generate:
+//
+//     {
+//         "type": "EventLogger",
+//         "imports": ["net"],
+//         "events": [{
+//             "name": "UserLogin",
+//             "lvl": "info",
+//             "msg": "user login",
+//             "fields": [
+//                 {"name": "userID", "key": "user_id", "type": "int64"},
+//                 {"name": "ip", "type": "net.IP"}
+//             ]
+//         }]
+//     }
+//
+// For each event a method named Log<Name> is generated on a type embedding
+// log.Logger, so the call above produces:
+//
+//     func (l EventLogger) LogUserLogin(userID int64, ip net.IP)
+//
+// A field's key defaults to its name when omitted. Field names must be
+// distinct Go identifiers other than l, the name of the receiver. To have
+// records report the caller of the generated method rather than the
+// method itself, wrap a child logger and enable caller capture on it
+// only, leaving other users of the parent unaffected:
+//
+//     child := logger.New()
+//     child.EnableCaller(1)
+//     events := EventLogger{child}
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/semihalev/log"
+)
+
+type schema struct {
+	Type    string   `json:"type"`
+	Imports []string `json:"imports"`
+	Events  []event  `json:"events"`
+}
+
+type event struct {
+	Name   string  `json:"name"`
+	Lvl    string  `json:"lvl"`
+	Msg    string  `json:"msg"`
+	Fields []field `json:"fields"`
+}
+
+type field struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+	Type string `json:"type"`
+}
+
+var tmpl = template.Must(template.New("loggen").Funcs(template.FuncMap{
+	"method": lvlMethod,
+}).Parse(`// Code generated by loggen. DO NOT EDIT.
+
+package {{.Pkg}}
+
+import (
+{{- range .Imports}}
+	"{{.}}"
+{{- end}}
+
+	"github.com/semihalev/log"
+)
+
+// {{.Type}} wraps a log.Logger with typed methods for each event of the schema.
+type {{.Type}} struct {
+	log.Logger
+}
+{{range .Events}}
+// Log{{.Name}} logs the {{printf "%q" .Msg}} event.
+func (l {{$.Type}}) Log{{.Name}}({{range $i, $f := .Fields}}{{if $i}}, {{end}}{{$f.Name}} {{$f.Type}}{{end}}) {
+	l.{{method .Lvl}}({{printf "%q" .Msg}}{{range .Fields}}, {{printf "%q" .Key}}, {{.Name}}{{end}})
+}
+{{end}}`))
+
+func lvlMethod(lvl string) string {
+	l, _ := log.LvlFromString(lvl)
+	switch l {
+	case log.LvlCrit:
+		return "Crit"
+	case log.LvlError:
+		return "Error"
+	case log.LvlWarn:
+		return "Warn"
+	case log.LvlInfo:
+		return "Info"
+	default:
+		return "Debug"
+	}
+}
+
+// validate checks the schema and fills in defaults.
+func (s *schema) validate() error {
+	if s.Type == "" {
+		s.Type = "EventLogger"
+	}
+	if !token.IsIdentifier(s.Type) {
+		return fmt.Errorf("invalid type name %q", s.Type)
+	}
+
+	seen := make(map[string]bool)
+	for i := range s.Events {
+		ev := &s.Events[i]
+		if !token.IsIdentifier(ev.Name) {
+			return fmt.Errorf("invalid event name %q", ev.Name)
+		}
+		if seen[ev.Name] {
+			return fmt.Errorf("duplicate event %q", ev.Name)
+		}
+		seen[ev.Name] = true
+
+		if ev.Lvl == "" {
+			ev.Lvl = "info"
+		}
+		if _, err := log.LvlFromString(ev.Lvl); err != nil {
+			return fmt.Errorf("event %s: %v", ev.Name, err)
+		}
+		if ev.Msg == "" {
+			ev.Msg = ev.Name
+		}
+
+		names := make(map[string]bool)
+		for j := range ev.Fields {
+			f := &ev.Fields[j]
+			// l is the receiver of the generated methods
+			if !token.IsIdentifier(f.Name) || token.IsKeyword(f.Name) || f.Name == "l" {
+				return fmt.Errorf("event %s: invalid field name %q", ev.Name, f.Name)
+			}
+			if names[f.Name] {
+				return fmt.Errorf("event %s: duplicate field %q", ev.Name, f.Name)
+			}
+			names[f.Name] = true
+			if f.Type == "" {
+				return fmt.Errorf("event %s: field %s has no type", ev.Name, f.Name)
+			}
+			if f.Key == "" {
+				f.Key = f.Name
+			}
+		}
+	}
+	return nil
+}
+
+// generate renders the Go source for the schema in package pkg.
+func generate(s *schema, pkg string) ([]byte, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, struct {
+		*schema
+		Pkg string
+	}{s, pkg})
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated invalid code: %v", err)
+	}
+	return src, nil
+}
+
+func main() {
+	var (
+		schemaPath = flag.String("schema", "", "path of the JSON event schema")
+		out        = flag.String("o", "", "output file (default stdout)")
+		pkg        = flag.String("pkg", os.Getenv("GOPACKAGE"), "package name of the generated file")
+	)
+	flag.Parse()
+
+	if *schemaPath == "" || *pkg == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(*schemaPath, *out, *pkg); err != nil {
+		fmt.Fprintln(os.Stderr, "loggen:", strings.TrimSpace(err.Error()))
+		os.Exit(1)
+	}
+}
+
+func run(schemaPath, out, pkg string) error {
+	data, err := ioutil.ReadFile(schemaPath)
+	if err != nil {
+		return err
+	}
+
+	var s schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("%s: %v", schemaPath, err)
+	}
+
+	src, err := generate(&s, pkg)
+	if err != nil {
+		return err
+	}
+
+	if out == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	return ioutil.WriteFile(out, src, 0644)
+}
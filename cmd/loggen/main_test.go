@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	s := &schema{
+		Imports: []string{"net"},
+		Events: []event{{
+			Name: "UserLogin",
+			Msg:  "user login",
+			Fields: []field{
+				{Name: "userID", Key: "user_id", Type: "int64"},
+				{Name: "ip", Type: "net.IP"},
+			},
+		}},
+	}
+
+	src, err := generate(s, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"type EventLogger struct",
+		"func (l EventLogger) LogUserLogin(userID int64, ip net.IP) {",
+		`l.Info("user login", "user_id", userID, "ip", ip)`,
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Fatalf("generated code missing %q:\n%s", want, src)
+		}
+	}
+}
+
+func TestGenerateInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, s := range []*schema{
+		{Events: []event{{Name: "bad name"}}},
+		{Events: []event{{Name: "A"}, {Name: "A"}}},
+		{Events: []event{{Name: "A", Lvl: "loud"}}},
+		{Events: []event{{Name: "A", Fields: []field{{Name: "type", Type: "int"}}}}},
+		{Events: []event{{Name: "A", Fields: []field{{Name: "x"}}}}},
+		{Events: []event{{Name: "A", Fields: []field{{Name: "l", Type: "int"}}}}},
+		{Events: []event{{Name: "A", Fields: []field{{Name: "x", Type: "int"}, {Name: "x", Type: "string"}}}}},
+	} {
+		if _, err := generate(s, "app"); err == nil {
+			t.Fatalf("expected error for schema %+v", s)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package log
+
+import (
+	"io"
+	"os"
+
+	"github.com/mattn/go-colorable"
+	isatty "github.com/mattn/go-isatty"
+)
+
+// colorMode reports whether records written to f are for a terminal, and
+// whether they should be colored. Colors follow the terminal unless
+// NO_COLOR or CLICOLOR=0 turn them off, or FORCE_COLOR or CLICOLOR_FORCE
+// turn them on even if f is not a terminal, such as in CI logs.
+func colorMode(f *os.File) (terminal, color bool) {
+	terminal = isatty.IsTerminal(f.Fd())
+	switch {
+	case os.Getenv("NO_COLOR") != "":
+		return terminal, false
+	case envFlag("FORCE_COLOR"), envFlag("CLICOLOR_FORCE"):
+		return terminal, true
+	case os.Getenv("CLICOLOR") == "0":
+		return terminal, false
+	}
+	return terminal, terminal
+}
+
+func envFlag(key string) bool {
+	v := os.Getenv(key)
+	return v != "" && v != "0" && v != "false"
+}
+
+// terminalOutput returns the writer and format for records written to f
+// for a human, reporting false if neither a terminal nor colors are
+// involved. On Windows it enables ANSI escape codes in the console, or
+// translates them if that fails.
+func terminalOutput(f *os.File) (io.Writer, Format, bool) {
+	terminal, color := colorMode(f)
+	if !color {
+		return f, TerminalFormatWith(TerminalOptions{NoColor: true}), terminal
+	}
+	enableVirtualTerminal(f)
+	return colorable.NewColorable(f), TerminalFormat(), true
+}
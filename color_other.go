@@ -0,0 +1,7 @@
+// +build !windows
+
+package log
+
+import "os"
+
+func enableVirtualTerminal(f *os.File) {}
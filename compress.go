@@ -0,0 +1,97 @@
+package log
+
+import (
+	"compress/gzip"
+	"io"
+	"sync"
+	"time"
+)
+
+// A CompressingWriter is an io.Writer compressing the data written to it
+// with gzip, for logs bound by disk or network bandwidth. Records are
+// collected in compressed blocks; a block is written out every
+// flushInterval if it is positive, when Flush is called, and on Close.
+//
+//     w, err := log.NewCompressingWriter(f, gzip.DefaultCompression, time.Second)
+//     ...
+//     log.OnExit(func() { w.Close() })
+//     log.Root().SetHandler(log.StreamHandler(w, log.JSONFormat()))
+//
+// The output is a gzip stream, read back with gzip.NewReader, e.g. into a
+// Decoder. Records of a stream cut short by a crash can be read up to the
+// last flushed block. Put an EncryptingWriter below it to compress before
+// encrypting. gzip writes its header and each piece of compressed output
+// separately, so a block usually spans several encrypted frames.
+type CompressingWriter struct {
+	mu    sync.Mutex
+	zw    *gzip.Writer
+	dirty bool
+	stop  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NewCompressingWriter returns a CompressingWriter writing to w at the
+// given gzip compression level.
+func NewCompressingWriter(w io.Writer, level int, flushInterval time.Duration) (*CompressingWriter, error) {
+	zw, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return nil, err
+	}
+	cw := &CompressingWriter{
+		zw:   zw,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if flushInterval > 0 {
+		go cw.flushEvery(flushInterval)
+	} else {
+		close(cw.done)
+	}
+	return cw, nil
+}
+
+// Write compresses p into the current block.
+func (w *CompressingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.dirty = true
+	return w.zw.Write(p)
+}
+
+// Flush writes the current block out, if anything was written since the
+// last flush.
+func (w *CompressingWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.dirty {
+		return nil
+	}
+	w.dirty = false
+	return w.zw.Flush()
+}
+
+// Close writes the current block and the end of the gzip stream. It does
+// not close the underlying writer.
+func (w *CompressingWriter) Close() error {
+	w.once.Do(func() { close(w.stop) })
+	<-w.done
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.zw.Close()
+}
+
+func (w *CompressingWriter) flushEvery(interval time.Duration) {
+	defer close(w.done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			_ = w.Flush()
+		case <-w.stop:
+			return
+		}
+	}
+}
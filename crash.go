@@ -0,0 +1,62 @@
+package log
+
+import (
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
+)
+
+// FlushOnSignal makes the given signals, SIGINT and SIGTERM if none are
+// given, log a record at LvlCrit on the root logger, run the hooks
+// registered with OnExit and end the program with the exit function, so
+// buffered records survive the process being stopped:
+//
+//     h := logext.AsyncHandler(1024, logext.Block, log.Must.FileHandler(path, log.JSONFormat()))
+//     log.Root().SetHandler(h)
+//     log.OnExit(h.Close)
+//     defer log.FlushOnSignal()()
+//
+// The exit code is 128 plus the signal number, as shells report it.
+// Calling the returned function restores the default signal handling.
+func FlushOnSignal(sigs ...os.Signal) (stop func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, sigs...)
+	go func() {
+		select {
+		case sig := <-ch:
+			root.write("terminated by signal", LvlCrit, []interface{}{"signal", sig.String()})
+			code := 1
+			if s, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(s)
+			}
+			exit(code)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
+
+// FlushOnPanic is meant to be deferred at the top of main and of
+// goroutines. If the goroutine panics, it logs the panic value and stack
+// at LvlCrit on the root logger and runs the hooks registered with
+// OnExit before letting the panic continue:
+//
+//     defer log.FlushOnPanic()
+//
+func FlushOnPanic() {
+	p := recover()
+	if p == nil {
+		return
+	}
+	root.write("panic", LvlCrit, []interface{}{"panic", p, "stack", string(debug.Stack())})
+	runExitHooks()
+	panic(p)
+}
@@ -0,0 +1,183 @@
+package log
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// maxFrame bounds the size of an encrypted frame accepted by a
+// DecryptingReader, so a corrupt length cannot exhaust memory.
+const maxFrame = 64 << 20
+
+// An EncryptingWriter is an io.Writer encrypting the data of every Write
+// with AES-GCM before passing it on, for storing logs that must not be
+// kept in plain text. Used with StreamHandler, every record is encrypted
+// on its own:
+//
+//     f, _ := os.OpenFile("app.log.enc", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+//     w, err := log.NewEncryptingWriter(f, key)
+//     ...
+//     log.Root().SetHandler(log.StreamHandler(w, log.JSONFormat()))
+//
+// Each Write is stored as a frame of a 4 byte big-endian length followed
+// by a random 12 byte nonce and the sealed data, or as several frames if
+// it is longer than 64MB. Read the frames back with a DecryptingReader.
+// The position of each frame in the writer's output is authenticated with
+// it, so the reader rejects frames that were dropped or reordered. A new
+// writer appending to the same file starts counting again, so removing
+// the frames of a whole writer, or the last frames of the file, goes
+// unnoticed. Nonces are random, so rotate the key well before four
+// billion records are written with it.
+type EncryptingWriter struct {
+	mu   sync.Mutex
+	w    io.Writer
+	aead cipher.AEAD
+	seq  uint64
+}
+
+// NewEncryptingWriter returns an EncryptingWriter writing to w with key,
+// which must be 16, 24 or 32 bytes long to select AES-128, AES-192 or
+// AES-256.
+func NewEncryptingWriter(w io.Writer, key []byte) (*EncryptingWriter, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptingWriter{w: w, aead: aead}, nil
+}
+
+// Write encrypts p and writes it as one frame, or as frames of at most
+// 64MB if p is longer.
+func (w *EncryptingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	max := maxFrame - w.aead.NonceSize() - w.aead.Overhead()
+	n := 0
+	for {
+		chunk := p[n:]
+		if len(chunk) > max {
+			chunk = chunk[:max]
+		}
+		if err := w.writeFrame(chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		if n == len(p) {
+			return n, nil
+		}
+	}
+}
+
+// writeFrame seals p as the next frame and writes it.
+func (w *EncryptingWriter) writeFrame(p []byte) error {
+	size := w.aead.NonceSize() + len(p) + w.aead.Overhead()
+	frame := make([]byte, 4+w.aead.NonceSize(), 4+size)
+	binary.BigEndian.PutUint32(frame, uint32(size))
+	nonce := frame[4:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	frame = w.aead.Seal(frame, nonce, p, frameAAD(w.seq))
+
+	if _, err := w.w.Write(frame); err != nil {
+		return err
+	}
+	w.seq++
+	return nil
+}
+
+// A DecryptingReader reads back the data written by an EncryptingWriter,
+// e.g. to decode the records with a Decoder:
+//
+//     r, err := log.NewDecryptingReader(f, key)
+//     ...
+//     d := log.NewDecoder(r)
+//
+type DecryptingReader struct {
+	r    io.Reader
+	aead cipher.AEAD
+	buf  []byte
+	seq  uint64
+}
+
+// ErrDecrypt is returned by a DecryptingReader for a frame that was not
+// encrypted with its key, was altered since, or is out of place.
+var ErrDecrypt = errors.New("log: cannot decrypt record")
+
+// NewDecryptingReader returns a DecryptingReader reading frames from r
+// with key.
+func NewDecryptingReader(r io.Reader, key []byte) (*DecryptingReader, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &DecryptingReader{r: r, aead: aead}, nil
+}
+
+// Read reads decrypted data. It returns io.ErrUnexpectedEOF if the stream
+// ends within a frame, and ErrDecrypt for a frame that fails to decrypt.
+func (r *DecryptingReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// next reads and decrypts the next frame into buf.
+func (r *DecryptingReader) next() error {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r.r, hdr[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(hdr[:])
+	if size < uint32(r.aead.NonceSize()+r.aead.Overhead()) || size > maxFrame {
+		return ErrDecrypt
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(r.r, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	nonce, sealed := frame[:r.aead.NonceSize()], frame[r.aead.NonceSize():]
+	buf, err := r.aead.Open(nil, nonce, sealed, frameAAD(r.seq))
+	if err != nil && r.seq > 0 {
+		// the first frame of another writer appending to the file
+		r.seq = 0
+		buf, err = r.aead.Open(nil, nonce, sealed, frameAAD(0))
+	}
+	if err != nil {
+		return ErrDecrypt
+	}
+	r.seq++
+	r.buf = buf
+	return nil
+}
+
+// frameAAD returns the additional data authenticating the position seq of
+// a frame.
+func frameAAD(seq uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], seq)
+	return b[:]
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
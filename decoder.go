@@ -0,0 +1,134 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A Decoder reads log records back from a stream of JSON lines, such as a
+// file written with JSONFormat. Lines written by zap and zerolog are
+// understood as well, see ParseJSON, and so are RFC 5424 syslog lines,
+// see ParseRFC5424. The format is detected line by line, so streams
+// mixing them can be read too.
+type Decoder struct {
+	rd      *bufio.Reader
+	partial []byte
+	off     int64
+	line    int
+	skip    bool
+	follow  bool
+	skipped int
+}
+
+// A DecodeError reports a line of the stream that is not a valid record.
+type DecodeError struct {
+	Line int
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("log: line %d: %v", e.Line, e.Err)
+}
+
+// NewDecoder returns a Decoder that reads records from rd.
+func NewDecoder(rd io.Reader) *Decoder {
+	return &Decoder{rd: bufio.NewReader(rd)}
+}
+
+// SkipInvalid makes Decode skip lines that are not valid records instead
+// of reporting them, for reading files damaged by a crash or by
+// concurrent writers. A line with garbage in front of a record, such as
+// the tail of a partially written line, is read from the start of that
+// record. Skipped reports how many lines were dropped.
+func (d *Decoder) SkipInvalid(skip bool) {
+	d.skip = skip
+}
+
+// Follow makes Decode treat a last line without a newline as a record
+// still being written, for following a file that grows: the line is kept,
+// without advancing Offset, and completed by the data read by the next
+// call of Decode. Otherwise such a line is decoded as the last record of
+// the stream.
+func (d *Decoder) Follow(follow bool) {
+	d.follow = follow
+}
+
+// Skipped returns the number of invalid lines skipped so far.
+func (d *Decoder) Skipped() int {
+	return d.skipped
+}
+
+// Offset returns the number of bytes of the stream read by Decode so far,
+// which is where the line following the last record returned starts. See
+// WriteCheckpoint for resuming from it.
+func (d *Decoder) Offset() int64 {
+	return d.off
+}
+
+// Decode returns the next record of the stream. Records must be separated
+// by newlines, as JSONFormat writes them, so output of JSONFormatEx that
+// is pretty-printed or not line separated cannot be read back. Blank
+// lines are skipped. It returns io.EOF when the stream is exhausted, see
+// Follow for streams that are still written to.
+// A line that cannot be parsed yields a *DecodeError unless invalid lines
+// are skipped; decoding may continue with the following line by calling
+// Decode again.
+func (d *Decoder) Decode() (*Record, error) {
+	for {
+		line, err := d.rd.ReadBytes('\n')
+		if len(d.partial) > 0 {
+			line = append(d.partial, line...)
+			d.partial = nil
+		}
+		if err != nil && (err != io.EOF || d.follow || len(line) == 0) {
+			d.partial = line
+			return nil, err
+		}
+		d.off += int64(len(line))
+		d.line++
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		r, perr := parseLine(line)
+		if perr != nil && d.skip {
+			r, perr = resync(line)
+		}
+		if perr == nil {
+			return r, nil
+		}
+		if !d.skip {
+			return nil, &DecodeError{Line: d.line, Err: perr}
+		}
+		d.skipped++
+	}
+}
+
+// parseLine parses a line in the format indicated by its first byte.
+func parseLine(line []byte) (*Record, error) {
+	switch line[0] {
+	case '{':
+		return ParseJSON(line)
+	case '<':
+		return ParseRFC5424(line)
+	}
+	return nil, errors.New("unrecognized record format")
+}
+
+// resync parses the first record found after the start of line.
+func resync(line []byte) (*Record, error) {
+	for i := 1; i < len(line); i++ {
+		if line[i] != '{' && line[i] != '<' {
+			continue
+		}
+		if r, err := parseLine(line[i:]); err == nil {
+			return r, nil
+		}
+	}
+	return nil, errors.New("no record found")
+}
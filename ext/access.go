@@ -0,0 +1,97 @@
+package ext
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/semihalev/log"
+)
+
+// AccessLogHandler returns an http.Handler that serves requests with h
+// and logs one record per request to l, with the message "http request"
+// and the context keys "method", "path", "status", "dur", "peer" and
+// "bytes", the number of bytes of the response body:
+//
+//     http.ListenAndServe(":8080", logext.AccessLogHandler(log.Root(), mux))
+//
+// Requests answered with a 5xx status are logged at LvlError, all others
+// at LvlInfo. The record is written after h returns, so it also covers
+// requests whose handler panics; the panic is passed on afterwards.
+func AccessLogHandler(l log.Logger, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		aw := &accessWriter{ResponseWriter: w}
+		defer func() {
+			status := aw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if p := recover(); p != nil {
+				status = http.StatusInternalServerError
+				defer panic(p)
+			}
+
+			ctx := []interface{}{
+				"method", r.Method,
+				"path", r.URL.RequestURI(),
+				"status", status,
+				"dur", time.Since(start),
+				"peer", r.RemoteAddr,
+				"bytes", aw.bytes,
+			}
+			if status >= 500 {
+				l.Error("http request", ctx...)
+			} else {
+				l.Info("http request", ctx...)
+			}
+		}()
+		h.ServeHTTP(aw, r)
+	})
+}
+
+// accessWriter records the status and body size of a response.
+type accessWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher if the wrapped writer does.
+func (w *accessWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker if the wrapped writer does, so
+// websocket upgrades keep working.
+func (w *accessWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, errors.New("log: response writer does not support hijacking")
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController.
+func (w *accessWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,293 @@
+package ext
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/semihalev/log"
+)
+
+// OverflowPolicy decides what an Async handler does with a record when its
+// buffer is full.
+type OverflowPolicy int
+
+// List of overflow policies
+const (
+	// Block waits until the buffer has room, like log.BufferedHandler.
+	Block OverflowPolicy = iota
+	// DropNewest discards the record being logged.
+	DropNewest
+	// DropOldest discards the oldest buffered record to make room.
+	DropOldest
+	// WriteSync writes the record to the wrapped handler on the calling
+	// goroutine. It is then written before the records still waiting in
+	// the buffer.
+	WriteSync
+)
+
+// AsyncHandler returns a handler that writes records to the wrapped handler
+// from a separate goroutine through a buffer of the given size. Unlike
+// log.BufferedHandler it does not have to block the logging goroutine
+// when the buffer is full: the given policy decides what happens to the
+// record instead, and Stats reports how many records were dropped so load
+// spikes do not go unnoticed.
+//
+// Errors from the wrapped handler are ignored, except for records written
+// synchronously under the WriteSync policy, which also requires h to be
+// safe for concurrent use. Call Close to write out the buffered records
+// before the program exits.
+//
+// log.Lazy values are evaluated by the wrapped handler, on the handler's
+// goroutine, so expensive fields can be resolved off the logging path.
+func AsyncHandler(bufSize int, policy OverflowPolicy, h log.Handler) *Async {
+	return AsyncBatchHandler(bufSize, policy, 1, 0, h)
+}
+
+// AsyncBatchHandler is like AsyncHandler but its goroutine collects up to
+// batchSize buffered records, waiting at most flushInterval for a batch to
+// fill, and passes them to the wrapped handler together. If h implements
+// BatchHandler, such as the handler returned by BatchStreamHandler, the
+// whole batch is written with a single LogBatch call; otherwise each
+// record is logged in turn.
+func AsyncBatchHandler(bufSize int, policy OverflowPolicy, batchSize int, flushInterval time.Duration, h log.Handler) *Async {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	a := &Async{
+		synclvl:  -1,
+		resvlvl:  -1,
+		policy:   policy,
+		handler:  h,
+		batch:    batchSize,
+		interval: flushInterval,
+		recs:     make(chan *log.Record, bufSize),
+		done:     make(chan struct{}),
+	}
+	go a.consume()
+	return a
+}
+
+// Async is the log.Handler. Read `AsyncHandler` for more information.
+type Async struct {
+	dropped uint64
+	sync    uint64
+	synclvl int64
+	resvlvl int64
+	reserve int64
+
+	policy   OverflowPolicy
+	handler  log.Handler
+	batch    int
+	interval time.Duration
+	recs     chan *log.Record
+	done     chan struct{}
+	once     sync.Once
+
+	// mu guards sending to recs against Close closing it
+	mu     sync.RWMutex
+	closed bool
+}
+
+// AsyncStats counts the records an Async handler did not write from its
+// buffer.
+type AsyncStats struct {
+	// Dropped is the number of records discarded because the buffer was
+	// full, or its reserve was reached.
+	Dropped uint64
+	// Sync is the number of records written on the logging goroutine
+	// because the buffer was full.
+	Sync uint64
+}
+
+// Log implements log.Handler interface
+func (h *Async) Log(r *log.Record) error {
+	if r.Lvl <= log.Lvl(atomic.LoadInt64(&h.synclvl)) {
+		return h.handler.Log(r)
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.closed {
+		atomic.AddUint64(&h.dropped, 1)
+		return nil
+	}
+	if r.Lvl > log.Lvl(atomic.LoadInt64(&h.resvlvl)) {
+		if n := atomic.LoadInt64(&h.reserve); n > 0 && len(h.recs) >= cap(h.recs)-int(n) {
+			atomic.AddUint64(&h.dropped, 1)
+			return nil
+		}
+	}
+
+	switch h.policy {
+	case DropNewest:
+		select {
+		case h.recs <- r:
+		default:
+			atomic.AddUint64(&h.dropped, 1)
+		}
+	case DropOldest:
+		for {
+			select {
+			case h.recs <- r:
+				return nil
+			default:
+			}
+			select {
+			case <-h.recs:
+				atomic.AddUint64(&h.dropped, 1)
+			default:
+			}
+		}
+	case WriteSync:
+		select {
+		case h.recs <- r:
+		default:
+			atomic.AddUint64(&h.sync, 1)
+			return h.handler.Log(r)
+		}
+	default:
+		h.recs <- r
+	}
+	return nil
+}
+
+// SyncLevel makes the handler write records at lvl or more severe to the
+// wrapped handler on the logging goroutine, bypassing the buffer. Such
+// records reach h before Log returns, even if the buffered records are
+// lost in a crash, and are never dropped. Records buffered earlier may be
+// written after them. h must be safe for concurrent use.
+func (h *Async) SyncLevel(lvl log.Lvl) {
+	atomic.StoreInt64(&h.synclvl, int64(lvl))
+}
+
+// Reserve keeps the last slots of the buffer for records at lvl or more
+// severe: once fewer than slots are free, less severe records are
+// dropped, whatever the overflow policy, so under pressure debug and info
+// records are shed first and room is left for errors. Records at the
+// SyncLevel are never dropped. Zero slots disable the reserve.
+func (h *Async) Reserve(lvl log.Lvl, slots int) {
+	atomic.StoreInt64(&h.resvlvl, int64(lvl))
+	atomic.StoreInt64(&h.reserve, int64(slots))
+}
+
+// Dropped returns the number of records discarded so far.
+func (h *Async) Dropped() uint64 {
+	return atomic.LoadUint64(&h.dropped)
+}
+
+// Stats returns the overflow counters of the handler.
+func (h *Async) Stats() AsyncStats {
+	return AsyncStats{
+		Dropped: atomic.LoadUint64(&h.dropped),
+		Sync:    atomic.LoadUint64(&h.sync),
+	}
+}
+
+// Close waits until all buffered records are written to the wrapped
+// handler. Records logged after Close is called are dropped and counted,
+// so it is safe to close the handler while other goroutines still log,
+// e.g. from log.OnExit.
+func (h *Async) Close() {
+	h.once.Do(func() {
+		h.mu.Lock()
+		h.closed = true
+		close(h.recs)
+		h.mu.Unlock()
+	})
+	<-h.done
+}
+
+func (h *Async) consume() {
+	defer close(h.done)
+	batch := make([]*log.Record, 0, h.batch)
+	for r := range h.recs {
+		batch = h.collect(append(batch[:0], r))
+		h.write(batch)
+	}
+}
+
+// collect appends buffered records to batch until it is full, the buffer
+// stays empty for the flush interval or the handler is closed.
+func (h *Async) collect(batch []*log.Record) []*log.Record {
+	var timeout <-chan time.Time
+	if h.interval > 0 && len(batch) < h.batch {
+		t := time.NewTimer(h.interval)
+		defer t.Stop()
+		timeout = t.C
+	}
+	for len(batch) < h.batch {
+		select {
+		case r, ok := <-h.recs:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, r)
+		default:
+			if timeout == nil {
+				return batch
+			}
+			select {
+			case r, ok := <-h.recs:
+				if !ok {
+					return batch
+				}
+				batch = append(batch, r)
+			case <-timeout:
+				return batch
+			}
+		}
+	}
+	return batch
+}
+
+func (h *Async) write(batch []*log.Record) {
+	if bh, ok := h.handler.(BatchHandler); ok {
+		_ = bh.LogBatch(batch)
+		return
+	}
+	for _, r := range batch {
+		_ = h.handler.Log(r)
+	}
+}
+
+// BatchHandler is a log.Handler that can write several records at once.
+type BatchHandler interface {
+	log.Handler
+	LogBatch(rs []*log.Record) error
+}
+
+// BatchStreamHandler is like log.StreamHandler but also implements
+// BatchHandler: LogBatch formats all records of a batch into one buffer
+// and writes it with a single call to wr, which saves a system call per
+// record on files and sockets.
+func BatchStreamHandler(wr io.Writer, fmtr log.Format) BatchHandler {
+	h := &batchStream{wr: wr}
+	h.lazy = log.LazyHandler(log.FuncHandler(func(r *log.Record) error {
+		h.buf = append(h.buf, fmtr.Format(r)...)
+		return nil
+	}))
+	return h
+}
+
+type batchStream struct {
+	mu   sync.Mutex
+	wr   io.Writer
+	lazy log.Handler
+	buf  []byte
+}
+
+func (h *batchStream) Log(r *log.Record) error {
+	return h.LogBatch([]*log.Record{r})
+}
+
+func (h *batchStream) LogBatch(rs []*log.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buf = h.buf[:0]
+	for _, r := range rs {
+		_ = h.lazy.Log(r)
+	}
+	_, err := h.wr.Write(h.buf)
+	return err
+}
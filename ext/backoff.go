@@ -0,0 +1,103 @@
+package ext
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/semihalev/log"
+)
+
+// Backoff is a retry policy with exponential backoff: the delay before
+// the first retry is Min and doubles for every further retry up to Max.
+// Jitter, between 0 and 1, randomly shortens each delay by up to that
+// fraction, so clients failing together do not retry in lockstep.
+//
+// A zero Min means 100ms, so the zero Backoff never retries in a busy
+// loop. MaxAttempts limits the total number of attempts and MaxElapsed
+// the time spent retrying; zero means no limit.
+type Backoff struct {
+	Min         time.Duration
+	Max         time.Duration
+	Jitter      float64
+	MaxAttempts int
+	MaxElapsed  time.Duration
+}
+
+// DefaultBackoff is the policy ReconnectHandler starts with.
+var DefaultBackoff = Backoff{Min: 100 * time.Millisecond, Max: 30 * time.Second}
+
+// Delay returns the time to wait after the given number of failed
+// attempts, starting at 1.
+func (b Backoff) Delay(failures int) time.Duration {
+	d := b.Min
+	if d <= 0 {
+		d = DefaultBackoff.Min
+	}
+	for i := 1; i < failures && (b.Max <= 0 || d < b.Max) && d <= math.MaxInt64/2; i++ {
+		d *= 2
+	}
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+	if b.Jitter > 0 {
+		d -= time.Duration(float64(d) * b.Jitter * rand.Float64())
+	}
+	return d
+}
+
+// RetryHandler returns a handler that retries records h fails to write,
+// waiting between attempts as given by the policy, and returns the last
+// error once the policy gives up. It blocks the logging goroutine while
+// retrying, so put it behind an AsyncHandler for remote handlers such as
+// OTLPHandler:
+//
+//     b := logext.Backoff{Min: time.Second, Max: time.Minute, Jitter: 0.2, MaxElapsed: 5 * time.Minute}
+//     h := logext.AsyncBatchHandler(1024, logext.DropOldest, 256, time.Second,
+//         logext.RetryHandler(b, logext.OTLPHandler(url, nil)))
+//
+// Without MaxAttempts and MaxElapsed a record is retried until it is
+// written. If h implements BatchHandler, so does the returned handler.
+func RetryHandler(b Backoff, h log.Handler) log.Handler {
+	rh := &retryHandler{policy: b, handler: h}
+	if bh, ok := h.(BatchHandler); ok {
+		return &retryBatchHandler{rh, bh}
+	}
+	return rh
+}
+
+type retryHandler struct {
+	policy  Backoff
+	handler log.Handler
+}
+
+func (h *retryHandler) Log(r *log.Record) error {
+	return h.retry(func() error { return h.handler.Log(r) })
+}
+
+func (h *retryHandler) retry(fn func() error) error {
+	start := time.Now()
+	for failures := 1; ; failures++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if h.policy.MaxAttempts > 0 && failures >= h.policy.MaxAttempts {
+			return err
+		}
+		d := h.policy.Delay(failures)
+		if h.policy.MaxElapsed > 0 && time.Since(start)+d > h.policy.MaxElapsed {
+			return err
+		}
+		time.Sleep(d)
+	}
+}
+
+type retryBatchHandler struct {
+	*retryHandler
+	batch BatchHandler
+}
+
+func (h *retryBatchHandler) LogBatch(rs []*log.Record) error {
+	return h.retry(func() error { return h.batch.LogBatch(rs) })
+}
@@ -0,0 +1,127 @@
+package ext
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/semihalev/log"
+)
+
+// BaggageHeader is the HTTP header carrying log context between services.
+// Its value follows the W3C Baggage format: comma separated key=value
+// pairs with percent-encoded keys and values.
+const BaggageHeader = "Baggage"
+
+// InjectBaggage adds the given key/value pairs to the baggage header of h
+// so that the receiving service can attach them to its loggers with
+// BaggageLogger. Values are sent as their fmt.Sprint representation and
+// pairs whose key is not a string are skipped.
+func InjectBaggage(h http.Header, ctx ...interface{}) {
+	var members []string
+	for i := 0; i+1 < len(ctx); i += 2 {
+		k, ok := ctx[i].(string)
+		if !ok {
+			continue
+		}
+		members = append(members, escapeBaggage(k)+"="+escapeBaggage(fmt.Sprint(ctx[i+1])))
+	}
+	if len(members) == 0 {
+		return
+	}
+
+	if prev := h.Get(BaggageHeader); prev != "" {
+		members = append([]string{prev}, members...)
+	}
+	h.Set(BaggageHeader, strings.Join(members, ","))
+}
+
+// ExtractBaggage returns the key/value pairs of the baggage headers of h.
+// Malformed members and member properties are ignored.
+func ExtractBaggage(h http.Header) []interface{} {
+	var ctx []interface{}
+	for _, v := range h[http.CanonicalHeaderKey(BaggageHeader)] {
+		for _, member := range strings.Split(v, ",") {
+			if i := strings.IndexByte(member, ';'); i >= 0 {
+				member = member[:i]
+			}
+			kv := strings.SplitN(strings.TrimSpace(member), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			k, err := url.PathUnescape(strings.TrimSpace(kv[0]))
+			if err != nil || k == "" {
+				continue
+			}
+			val, err := url.PathUnescape(strings.TrimSpace(kv[1]))
+			if err != nil {
+				continue
+			}
+			ctx = append(ctx, k, val)
+		}
+	}
+	return ctx
+}
+
+// BaggageLogger returns a child of l carrying the baggage of the request
+// as its context.
+func BaggageLogger(l log.Logger, r *http.Request) log.Logger {
+	return l.New(ExtractBaggage(r.Header)...)
+}
+
+// BaggageTransport returns an http.RoundTripper that adds the baggage
+// returned by fn for each outgoing request before passing it to rt. A nil
+// rt uses http.DefaultTransport. For example, to forward a request id:
+//
+//     client := &http.Client{Transport: logext.BaggageTransport(nil,
+//         func(r *http.Request) []interface{} {
+//             return []interface{}{"req_id", requestID(r.Context())}
+//         })}
+//
+func BaggageTransport(rt http.RoundTripper, fn func(r *http.Request) []interface{}) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		ctx := fn(r)
+		if len(ctx) == 0 {
+			return rt.RoundTrip(r)
+		}
+
+		// a RoundTripper must not modify the caller's request
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.Header = make(http.Header, len(r.Header)+1)
+		for k, v := range r.Header {
+			r2.Header[k] = append([]string(nil), v...)
+		}
+		InjectBaggage(r2.Header, ctx...)
+		return rt.RoundTrip(r2)
+	})
+}
+
+type roundTripperFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// escapeBaggage percent-encodes everything but unreserved characters.
+func escapeBaggage(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&15])
+		}
+	}
+	return b.String()
+}
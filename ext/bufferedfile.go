@@ -0,0 +1,174 @@
+package ext
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/semihalev/log"
+)
+
+// SyncPolicy decides when a BufferedFile handler calls fsync.
+type SyncPolicy int
+
+// List of sync policies
+const (
+	// SyncNever leaves writing data to disk to the operating system.
+	SyncNever SyncPolicy = iota
+	// SyncInterval syncs the file after every periodic flush.
+	SyncInterval
+	// SyncRecord flushes and syncs the file after every record, so a
+	// record is on disk when Log returns.
+	SyncRecord
+)
+
+// BufferedFileHandler is like log.FileHandler but collects formatted
+// records in a buffer of bufSize bytes in user space, instead of making a
+// write system call per record. The buffer is written to the file when it
+// is full, every flushInterval if it is positive, and on Flush and Close.
+// The file is opened for appending and created with mode 0644 if needed.
+//
+// Records still in the buffer are lost if the process dies; pick the
+// flush interval and sync policy for the amount of loss that is
+// acceptable, and call Close, e.g. from log.OnExit, before exiting.
+//
+// The file is opened close-on-exec, so a process that re-executes itself,
+// e.g. to daemonize, does not pass it on; the new process sets up its own
+// handlers. Go programs cannot fork without exec, so the flushing
+// goroutine is never duplicated.
+func BufferedFileHandler(path string, fmtr log.Format, bufSize int, flushInterval time.Duration, policy SyncPolicy) (*BufferedFile, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &BufferedFile{
+		path:   path,
+		f:      f,
+		w:      bufio.NewWriterSize(f, bufSize),
+		policy: policy,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	h.lazy = log.LazyHandler(log.FuncHandler(func(r *log.Record) error {
+		return h.write(fmtr.Format(r))
+	}))
+
+	if flushInterval > 0 {
+		go h.flushEvery(flushInterval)
+	} else {
+		close(h.done)
+	}
+	return h, nil
+}
+
+// BufferedFile is the log.Handler. Read `BufferedFileHandler` for more information.
+type BufferedFile struct {
+	mu     sync.Mutex
+	path   string
+	f      *os.File
+	w      *bufio.Writer
+	policy SyncPolicy
+	lazy   log.Handler
+	stop   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+	closed bool
+}
+
+var errFileClosed = errors.New("buffered file: closed")
+
+// Log implements log.Handler interface
+func (h *BufferedFile) Log(r *log.Record) error {
+	return h.lazy.Log(r)
+}
+
+// Flush writes the buffered records to the file, and syncs it unless the
+// policy is SyncNever.
+func (h *BufferedFile) Flush() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.flush(h.policy != SyncNever)
+}
+
+// Reopen flushes the buffer, closes the file and opens the path again,
+// e.g. after logrotate moved the file away, or when SIGHUP is received.
+func (h *BufferedFile) Reopen() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return errFileClosed
+	}
+
+	err := h.flush(h.policy != SyncNever)
+	if cerr := h.f.Close(); err == nil {
+		err = cerr
+	}
+	f, oerr := os.OpenFile(h.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if oerr != nil {
+		// keep the closed file, so writes fail until Reopen succeeds
+		return oerr
+	}
+	h.f = f
+	h.w.Reset(f)
+	return err
+}
+
+// Close flushes the buffer and closes the file. No records may be logged
+// to the handler after Close is called. Closing it again does nothing.
+func (h *BufferedFile) Close() error {
+	h.once.Do(func() { close(h.stop) })
+	<-h.done
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil
+	}
+	h.closed = true
+	err := h.flush(h.policy != SyncNever)
+	if cerr := h.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (h *BufferedFile) write(b []byte) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, err := h.w.Write(b); err != nil {
+		return err
+	}
+	if h.policy == SyncRecord {
+		return h.flush(true)
+	}
+	return nil
+}
+
+func (h *BufferedFile) flush(sync bool) error {
+	if err := h.w.Flush(); err != nil {
+		return err
+	}
+	if sync {
+		return h.f.Sync()
+	}
+	return nil
+}
+
+func (h *BufferedFile) flushEvery(interval time.Duration) {
+	defer close(h.done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			h.mu.Lock()
+			_ = h.flush(h.policy == SyncInterval)
+			h.mu.Unlock()
+		case <-h.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package ext
+
+import (
+	"context"
+	"time"
+)
+
+// CancelCtx returns key/value pairs explaining why ctx is done, for
+// logging alongside the error of an aborted operation:
+//
+//     if err := fetch(ctx); err != nil {
+//         l.Error("fetch failed", logext.CancelCtx(ctx)...)
+//     }
+//
+// The pairs are "ctx_err" with ctx.Err(), "ctx_cause" with the cause of
+// the cancellation when it differs from ctx.Err() (Go 1.20 and later), and
+// "ctx_deadline_remaining" with the time left until the deadline of ctx,
+// which is negative if the deadline has passed. CancelCtx returns nil if
+// ctx is not done.
+func CancelCtx(ctx context.Context) []interface{} {
+	err := ctx.Err()
+	if err == nil {
+		return nil
+	}
+
+	pairs := []interface{}{"ctx_err", err}
+	if cause := contextCause(ctx); cause != nil && cause != err {
+		pairs = append(pairs, "ctx_cause", cause)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		pairs = append(pairs, "ctx_deadline_remaining", time.Until(deadline))
+	}
+	return pairs
+}
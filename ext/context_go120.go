@@ -0,0 +1,9 @@
+// +build go1.20
+
+package ext
+
+import "context"
+
+func contextCause(ctx context.Context) error {
+	return context.Cause(ctx)
+}
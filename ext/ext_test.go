@@ -1,9 +1,26 @@
 package ext
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/semihalev/log"
 )
@@ -74,6 +91,7 @@ func TestSpeculativeHandler(t *testing.T) {
 		}()
 
 		lg := log.New()
+		lg.SetLevel(log.LvlDebug)
 		lg.SetHandler(spec)
 		for i := 0; i < count; i++ {
 			lg.Debug("test speculative", "i", i)
@@ -91,6 +109,7 @@ func TestErrorHandler(t *testing.T) {
 
 	h, r := testHandler()
 	lg := log.New()
+	lg.SetLevel(log.LvlDebug)
 	lg.SetHandler(EscalateErrHandler(
 		log.LvlFilterHandler(log.LvlError, h)))
 
@@ -108,3 +127,1135 @@ func TestErrorHandler(t *testing.T) {
 		t.Fatalf("Expected debug level message to be escalated to LvlError")
 	}
 }
+
+func TestRollupHandler(t *testing.T) {
+	t.Parallel()
+
+	var rollups []*log.Record
+	h := RollupHandler(5*time.Minute, log.FuncHandler(func(r *log.Record) error {
+		rollups = append(rollups, r)
+		return nil
+	}))
+
+	start := time.Date(2020, 4, 27, 15, 0, 0, 0, time.UTC)
+	logAt := func(offset time.Duration, lvl log.Lvl, msg string) {
+		h.Log(&log.Record{Time: start.Add(offset), Lvl: lvl, Msg: msg})
+	}
+
+	logAt(0, log.LvlInfo, "request")
+	logAt(10*time.Second, log.LvlInfo, "request")
+	logAt(20*time.Second, log.LvlError, "failed")
+	logAt(70*time.Second, log.LvlInfo, "request")
+	if len(rollups) != 0 {
+		t.Fatalf("Expected no roll-ups inside the window, got %d", len(rollups))
+	}
+
+	// the first minute leaves the window, the second one is incomplete
+	logAt(6*time.Minute+30*time.Second, log.LvlInfo, "request")
+	if len(rollups) != 2 {
+		t.Fatalf("Expected 2 roll-ups for the first minute, got %d", len(rollups))
+	}
+	if r := rollups[0]; r.Lvl != log.LvlError || r.Msg != "failed" || r.Ctx[1] != 1 || !r.Time.Equal(start) {
+		t.Fatalf("Wrong roll-up: %+v", r)
+	}
+	if r := rollups[1]; r.Lvl != log.LvlInfo || r.Msg != "request" || r.Ctx[1] != 2 {
+		t.Fatalf("Wrong roll-up: %+v", r)
+	}
+
+	rollups = nil
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(rollups) != 2 || rollups[0].Ctx[1] != 1 || rollups[1].Ctx[1] != 1 {
+		t.Fatalf("Expected flush to roll up the remaining minutes, got %d", len(rollups))
+	}
+}
+
+func TestAsyncHandler(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		policy  OverflowPolicy
+		msgs    []string
+		dropped uint64
+		sync    uint64
+	}{
+		{DropNewest, []string{"1", "2"}, 1, 0},
+		{DropOldest, []string{"1", "3"}, 1, 0},
+		{WriteSync, []string{"3", "1", "2"}, 0, 1},
+	} {
+		var (
+			mu      sync.Mutex
+			msgs    []string
+			started = make(chan struct{})
+			release = make(chan struct{})
+		)
+		h := AsyncHandler(1, tt.policy, log.FuncHandler(func(r *log.Record) error {
+			if r.Msg == "1" {
+				close(started)
+				<-release
+			}
+			mu.Lock()
+			msgs = append(msgs, r.Msg)
+			mu.Unlock()
+			return nil
+		}))
+
+		// "1" blocks the consumer, "2" fills the buffer and "3" overflows
+		h.Log(&log.Record{Msg: "1"})
+		<-started
+		h.Log(&log.Record{Msg: "2"})
+		h.Log(&log.Record{Msg: "3"})
+		close(release)
+		h.Close()
+
+		if fmt.Sprint(msgs) != fmt.Sprint(tt.msgs) {
+			t.Fatalf("Policy %d: expected %v written, got %v", tt.policy, tt.msgs, msgs)
+		}
+		if s := h.Stats(); s.Dropped != tt.dropped || s.Sync != tt.sync || h.Dropped() != tt.dropped {
+			t.Fatalf("Policy %d: wrong stats %+v", tt.policy, s)
+		}
+	}
+}
+
+func TestAsyncHandlerSyncLevel(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	var msgs []string
+	h := AsyncHandler(0, Block, log.FuncHandler(func(r *log.Record) error {
+		if r.Lvl != log.LvlError {
+			<-release
+		}
+		msgs = append(msgs, r.Msg)
+		return nil
+	}))
+	h.SyncLevel(log.LvlError)
+
+	// written synchronously even though the consumer is stuck
+	h.Log(&log.Record{Lvl: log.LvlInfo, Msg: "buffered"})
+	h.Log(&log.Record{Lvl: log.LvlError, Msg: "failed"})
+	if len(msgs) != 1 || msgs[0] != "failed" {
+		t.Fatalf("Expected error record to be written before Log returns, got %v", msgs)
+	}
+
+	close(release)
+	h.Close()
+	if len(msgs) != 2 || msgs[1] != "buffered" {
+		t.Fatalf("Expected buffered record to be written on close, got %v", msgs)
+	}
+}
+
+func TestAsyncHandlerReserve(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu      sync.Mutex
+		msgs    []string
+		started = make(chan struct{})
+		release = make(chan struct{})
+	)
+	h := AsyncHandler(2, Block, log.FuncHandler(func(r *log.Record) error {
+		if r.Msg == "block" {
+			close(started)
+			<-release
+		}
+		mu.Lock()
+		msgs = append(msgs, r.Msg)
+		mu.Unlock()
+		return nil
+	}))
+	h.Reserve(log.LvlWarn, 1)
+
+	h.Log(&log.Record{Lvl: log.LvlInfo, Msg: "block"})
+	<-started
+	h.Log(&log.Record{Lvl: log.LvlInfo, Msg: "info"})
+	h.Log(&log.Record{Lvl: log.LvlDebug, Msg: "shed"})
+	h.Log(&log.Record{Lvl: log.LvlError, Msg: "error"})
+	close(release)
+	h.Close()
+
+	if fmt.Sprint(msgs) != "[block info error]" {
+		t.Fatalf("Expected debug record to be shed, got %v", msgs)
+	}
+	if h.Dropped() != 1 {
+		t.Fatalf("Expected 1 dropped record, got %d", h.Dropped())
+	}
+}
+
+func TestAsyncHandlerLogAfterClose(t *testing.T) {
+	t.Parallel()
+
+	h := AsyncHandler(16, Block, log.DiscardHandler())
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				h.Log(&log.Record{Msg: "x"})
+			}
+		}()
+	}
+	h.Close()
+	wg.Wait()
+
+	h.Log(&log.Record{Msg: "late"})
+	if h.Dropped() == 0 {
+		t.Fatal("Expected records logged after Close to be counted as dropped")
+	}
+}
+
+func TestAsyncHandlerLazy(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	var buf bytes.Buffer
+	h := AsyncHandler(1, Block, log.StreamHandler(&buf, log.LogfmtFormat()))
+
+	// Log must not wait for the lazy value to be resolved
+	h.Log(&log.Record{
+		Msg:      "connection",
+		Ctx:      []interface{}{"host", log.Lazy{Fn: func() string { <-release; return "example.com" }}},
+		KeyNames: log.RecordKeyNames{Msg: "msg", Lvl: "lvl"},
+	})
+	close(release)
+	h.Close()
+
+	if buf.String() != "lvl=crit msg=connection host=example.com\n" {
+		t.Fatalf("Wrong output: %q", buf.String())
+	}
+}
+
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestAsyncBatchHandler(t *testing.T) {
+	t.Parallel()
+
+	w := new(countingWriter)
+	h := AsyncBatchHandler(10, Block, 3, time.Hour, BatchStreamHandler(w, log.LogfmtFormat()))
+	for i := 0; i < 5; i++ {
+		h.Log(&log.Record{Msg: fmt.Sprint(i), KeyNames: log.RecordKeyNames{Msg: "msg", Lvl: "lvl"}})
+	}
+	h.Close()
+
+	if w.writes != 2 {
+		t.Fatalf("Expected 5 records in 2 writes, got %d writes", w.writes)
+	}
+	if n := strings.Count(w.String(), "\n"); n != 5 {
+		t.Fatalf("Expected 5 lines, got %d: %q", n, w.String())
+	}
+}
+
+func TestCancelCtx(t *testing.T) {
+	t.Parallel()
+
+	if pairs := CancelCtx(context.Background()); pairs != nil {
+		t.Fatalf("Expected no pairs for a live context, got %v", pairs)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	pairs := CancelCtx(ctx)
+	if len(pairs) != 4 || pairs[0] != "ctx_err" || pairs[1] != context.DeadlineExceeded || pairs[2] != "ctx_deadline_remaining" {
+		t.Fatalf("Wrong pairs for an expired context: %v", pairs)
+	}
+	if d := pairs[3].(time.Duration); d >= 0 {
+		t.Fatalf("Expected negative remaining time, got %v", d)
+	}
+}
+
+func TestReconnectHandler(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	h := ReconnectHandler("tcp", addr, 2, log.FormatFunc(func(r *log.Record) []byte {
+		return []byte(r.Msg + "\n")
+	}))
+	defer h.Close()
+	if err := h.Check(); err == nil {
+		t.Fatal("Expected Check to fail while nobody is listening")
+	}
+
+	// nobody is listening, so the records are spilled and the oldest dropped
+	for _, msg := range []string{"1", "2", "3"} {
+		if err := h.Log(&log.Record{Msg: msg}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if h.Dropped() != 1 {
+		t.Fatalf("Expected 1 dropped record, got %d", h.Dropped())
+	}
+
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	time.Sleep(2 * DefaultBackoff.Min)
+
+	h.Log(&log.Record{Msg: "4"})
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	h.Close()
+
+	got, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "2\n3\n4\n" {
+		t.Fatalf("Expected spilled records before the new one, got %q", got)
+	}
+}
+
+func TestReconnectHandlerWriteTimeout(t *testing.T) {
+	t.Parallel()
+
+	// the server accepts connections but never reads from them
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	payload := bytes.Repeat([]byte("x"), 1<<20)
+	h := ReconnectHandler("tcp", ln.Addr().String(), 0, log.FormatFunc(func(r *log.Record) []byte {
+		return payload
+	}))
+	defer h.Close()
+	h.SetWriteTimeout(50 * time.Millisecond)
+
+	start := time.Now()
+	for h.Dropped() == 0 {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("Expected a write to time out")
+		}
+		if err := h.Log(&log.Record{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReconnectHandlerDialer(t *testing.T) {
+	t.Parallel()
+
+	var (
+		dials int
+		lines = make(chan string, 1)
+	)
+	h := ReconnectHandler("psk", "collector", 0, log.FormatFunc(func(r *log.Record) []byte {
+		return []byte(r.Msg + "\n")
+	}))
+	defer h.Close()
+	h.SetDialer(func(network, addr string) (net.Conn, error) {
+		if network != "psk" || addr != "collector" {
+			t.Errorf("Unexpected dial of %s %s", network, addr)
+		}
+		dials++
+		client, server := net.Pipe()
+		go func() {
+			b, _ := bufio.NewReader(server).ReadString('\n')
+			lines <- b
+			server.Close()
+		}()
+		return client, nil
+	})
+
+	if err := h.Check(); err != nil {
+		t.Fatal(err)
+	}
+	h.Log(&log.Record{Msg: "hi"})
+	if line := <-lines; line != "hi\n" {
+		t.Fatalf("Expected record through the dialed connection, got %q", line)
+	}
+	if dials != 1 {
+		t.Fatalf("Expected 1 dial, got %d", dials)
+	}
+}
+
+// tornConn accepts n bytes and then fails.
+type tornConn struct {
+	net.Conn
+	n   int
+	buf bytes.Buffer
+}
+
+func (c *tornConn) Write(b []byte) (int, error) {
+	if c.n >= 0 && len(b) > c.n {
+		c.buf.Write(b[:c.n])
+		n := c.n
+		c.n = 0
+		return n, errors.New("connection reset")
+	}
+	return c.buf.Write(b)
+}
+
+func (c *tornConn) Close() error {
+	return nil
+}
+
+func TestReconnectHandlerTornWrite(t *testing.T) {
+	t.Parallel()
+
+	conns := []*tornConn{{n: 3}, {n: -1}}
+	h := ReconnectHandler("tcp", "collector", 10, log.FormatFunc(func(r *log.Record) []byte {
+		return []byte(r.Msg + "\n")
+	}))
+	defer h.Close()
+	h.SetBackoff(Backoff{Min: time.Nanosecond})
+	h.SetDialer(func(network, addr string) (net.Conn, error) {
+		c := conns[0]
+		conns = conns[1:]
+		return c, nil
+	})
+
+	first, second := conns[0], conns[1]
+	h.Log(&log.Record{Msg: "torn"})
+	time.Sleep(time.Millisecond)
+	h.Log(&log.Record{Msg: "next"})
+	if first.buf.String() != "tor" || second.buf.String() != "next\n" {
+		t.Fatalf("Expected the torn record not to be resent, got %q and %q", first.buf.String(), second.buf.String())
+	}
+	if h.Dropped() != 1 {
+		t.Fatalf("Expected 1 dropped record, got %d", h.Dropped())
+	}
+}
+
+func TestOTLPHandler(t *testing.T) {
+	t.Parallel()
+
+	var req struct {
+		ResourceLogs []struct {
+			Resource struct {
+				Attributes []otlpKeyValue
+			}
+			ScopeLogs []struct {
+				LogRecords []otlpLogRecord
+			}
+		}
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	h := OTLPHandler(srv.URL, nil, "service.name", "api")
+	err := h.LogBatch([]*log.Record{
+		{Lvl: log.LvlError, Msg: "failed", Ctx: []interface{}{"trace_id", "0af7651916cd43dd8448eb211c80319c", "n", 3, "u", uint64(4), "big", uint64(math.MaxUint64)}},
+		{Lvl: log.LvlInfo, Msg: "done"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(req.ResourceLogs) != 1 || len(req.ResourceLogs[0].ScopeLogs) != 1 {
+		t.Fatalf("Wrong request: %+v", req)
+	}
+	if attrs := req.ResourceLogs[0].Resource.Attributes; len(attrs) != 1 || attrs[0].Key != "service.name" {
+		t.Fatalf("Wrong resource: %+v", attrs)
+	}
+	recs := req.ResourceLogs[0].ScopeLogs[0].LogRecords
+	if len(recs) != 2 {
+		t.Fatalf("Expected 2 log records, got %d", len(recs))
+	}
+	r := recs[0]
+	if r.SeverityNumber != 17 || r.Body["stringValue"] != "failed" || r.TraceID != "0af7651916cd43dd8448eb211c80319c" {
+		t.Fatalf("Wrong log record: %+v", r)
+	}
+	if len(r.Attributes) != 3 || r.Attributes[0].Key != "n" || r.Attributes[0].Value["intValue"] != "3" || r.Attributes[1].Value["intValue"] != "4" ||
+		r.Attributes[2].Value["stringValue"] != "18446744073709551615" {
+		t.Fatalf("Wrong attributes: %+v", r.Attributes)
+	}
+}
+
+func TestFluentHandler(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	msgs := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 1024)
+		n, _ := conn.Read(buf)
+		msg := buf[:n]
+		msgs <- msg
+
+		// the chunk id is the last 24 bytes of the option map
+		ack := append([]byte{0x81, 0xa3}, "ack"...)
+		ack = append(ack, 0xb8)
+		conn.Write(append(ack, msg[n-24:]...))
+	}()
+
+	h, err := FluentHandler("tcp", ln.Addr().String(), "app.test", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.Log(&log.Record{Msg: "hello", Lvl: log.LvlInfo, Ctx: []interface{}{"n", 1, "u", uint64(math.MaxUint64), "g", log.Group{"a", 1}},
+		KeyNames: log.RecordKeyNames{Msg: "msg", Lvl: "lvl"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := <-msgs
+	if !bytes.HasPrefix(msg, append([]byte{0x93, 0xa8}, "app.test"...)) {
+		t.Fatalf("Expected forward message with tag, got %q", msg)
+	}
+	for _, s := range []string{"\xa3msg\xa5hello", "\xa3lvl\xa4info", "\xa1n\x01", "\xa1u\xcf\xff\xff\xff\xff\xff\xff\xff\xff", "\xa1g\x81\xa1a\x01", "\xa5chunk"} {
+		if !bytes.Contains(msg, []byte(s)) {
+			t.Fatalf("Expected %q in message %q", s, msg)
+		}
+	}
+}
+
+func TestFluentHandlerRedial(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// the first connection answers with a wrong ack, the second one acks
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 1024)
+			n, _ := conn.Read(buf)
+			ack := append([]byte{0x81, 0xa3}, "ack"...)
+			ack = append(ack, 0xb8)
+			if i == 0 {
+				conn.Write(append(ack, bytes.Repeat([]byte("x"), 24)...))
+			} else {
+				conn.Write(append(ack, buf[n-24:n]...))
+			}
+			defer conn.Close()
+		}
+	}()
+
+	h, err := FluentHandler("tcp", ln.Addr().String(), "app.test", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &log.Record{Msg: "hello", KeyNames: log.RecordKeyNames{Msg: "msg", Lvl: "lvl"}}
+	if err := h.Log(rec); err == nil {
+		t.Fatal("Expected error for wrong ack")
+	}
+	if err := h.Log(rec); err != nil {
+		t.Fatalf("Expected record to be sent over a new connection, got %v", err)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	t.Parallel()
+
+	tid, sid, err := ParseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tid.String() != "0af7651916cd43dd8448eb211c80319c" || sid.String() != "b7ad6b7169203331" {
+		t.Fatalf("Wrong ids: %v %v", tid, sid)
+	}
+	for _, bad := range []string{"", "00-0af7651916cd43dd8448eb211c80319c", "00-00000000000000000000000000000000-b7ad6b7169203331-01"} {
+		if _, _, err := ParseTraceparent(bad); err == nil {
+			t.Fatalf("Expected error for %q", bad)
+		}
+	}
+
+	if pairs := TraceCtx(context.Background()); pairs != nil {
+		t.Fatalf("Expected no pairs without a trace, got %v", pairs)
+	}
+
+	h, r := testHandler()
+	l := log.New()
+	l.SetHandler(h)
+	TraceLogger(l, ContextWithTrace(context.Background(), tid, sid)).Info("traced")
+	if v, _ := r.Lookup("trace_id"); v != tid {
+		t.Fatalf("Expected trace id in context, got %v", r.Ctx)
+	}
+	if v, _ := r.Lookup("span_id"); v != sid {
+		t.Fatalf("Expected span id in context, got %v", r.Ctx)
+	}
+}
+
+func TestWatchLevel(t *testing.T) {
+	t.Parallel()
+
+	f, err := ioutil.TempFile("", "level")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("debug\n")
+	f.Close()
+
+	h, r := testHandler()
+	l := log.New()
+	l.SetHandler(h)
+	stop := WatchLevel(l, f.Name(), 10*time.Millisecond)
+	defer stop()
+
+	l.Debug("visible")
+	if r.Msg != "visible" {
+		t.Fatalf("Expected level to be read at start")
+	}
+
+	if err := ioutil.WriteFile(f.Name(), []byte("error"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(f.Name(), time.Now(), time.Now().Add(time.Hour))
+	for i := 0; i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		r.Msg = ""
+		l.Warn("probe")
+		if r.Msg != "probe" {
+			return
+		}
+	}
+	t.Fatalf("Expected level change to be picked up")
+}
+
+func TestLevelHTTPHandler(t *testing.T) {
+	t.Parallel()
+
+	l, db := log.New(), log.New()
+	srv := httptest.NewServer(LevelHTTPHandler(l, map[string]log.Logger{"db": db}))
+	defer srv.Close()
+
+	do := func(method, query, body string) (int, string) {
+		req, _ := http.NewRequest(method, srv.URL+query, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, strings.TrimSpace(string(b))
+	}
+
+	if code, body := do("GET", "", ""); code != 200 || body != `{"level":"info"}` {
+		t.Fatalf("Wrong GET response: %d %s", code, body)
+	}
+	if code, body := do("PUT", "?logger=db", `{"level":"debug"}`); code != 200 || body != `{"level":"dbug"}` {
+		t.Fatalf("Wrong PUT response: %d %s", code, body)
+	}
+	if db.GetLevel() != log.LvlDebug || l.GetLevel() != log.LvlInfo {
+		t.Fatalf("Expected only the named logger to change")
+	}
+	if code, _ := do("PUT", "", `{"level":"loud"}`); code != http.StatusBadRequest {
+		t.Fatalf("Expected bad request for unknown level, got %d", code)
+	}
+	if code, _ := do("GET", "?logger=cache", ""); code != http.StatusNotFound {
+		t.Fatalf("Expected not found for unknown logger, got %d", code)
+	}
+}
+
+func TestBaggage(t *testing.T) {
+	t.Parallel()
+
+	var got []interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, rec := testHandler()
+		l := log.New()
+		l.SetHandler(h)
+		BaggageLogger(l, r).Info("received")
+		got = rec.Ctx
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: BaggageTransport(nil, func(r *http.Request) []interface{} {
+		return []interface{}{"req_id", "a1b2", "user", "jane doe, admin=1", 42, "skipped", "n", 7}
+	})}
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set(BaggageHeader, "tenant=acme;prop=1")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	expected := []interface{}{"tenant", "acme", "req_id", "a1b2", "user", "jane doe, admin=1", "n", "7"}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Fatalf("Got baggage %v, expected %v", got, expected)
+	}
+	if req.Header.Get(BaggageHeader) != "tenant=acme;prop=1" {
+		t.Fatalf("Transport modified the caller's request header")
+	}
+}
+
+func TestFaultHandlers(t *testing.T) {
+	t.Parallel()
+
+	sink := NoopHandler()
+	r := &log.Record{Msg: "test"}
+
+	start := time.Now()
+	slow := SlowHandler(10*time.Millisecond, 5*time.Millisecond, sink)
+	for i := 0; i < 3; i++ {
+		if err := slow.Log(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Fatalf("Expected at least 30ms of latency, got %v", d)
+	}
+
+	failed := 0
+	flaky := FlakyHandler(0.5, sink)
+	for i := 0; i < 1000; i++ {
+		if err := flaky.Log(r); err == ErrInjected {
+			failed++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if failed < 350 || failed > 650 {
+		t.Fatalf("Expected about half of the writes to fail, got %d", failed)
+	}
+	if n := sink.Count(); n != uint64(3+1000-failed) {
+		t.Fatalf("Wrong count: %d", n)
+	}
+
+	if err := FlakyHandler(0, sink).Log(r); err != nil {
+		t.Fatalf("Expected no failures at rate 0, got %v", err)
+	}
+	if err := FlakyHandler(1, sink).Log(r); err != ErrInjected {
+		t.Fatalf("Expected failure at rate 1, got %v", err)
+	}
+}
+
+func TestAccessLogHandler(t *testing.T) {
+	t.Parallel()
+
+	h, r := testHandler()
+	l := log.New()
+	l.SetHandler(h)
+
+	srv := AccessLogHandler(l, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/missing":
+			http.NotFound(w, req)
+		case "/panic":
+			panic("boom")
+		default:
+			w.Write([]byte("hello"))
+		}
+	}))
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/hello?x=1", nil))
+	if r.Msg != "http request" || r.Lvl != log.LvlInfo {
+		t.Fatalf("Wrong record: %+v", r)
+	}
+	for k, want := range map[string]interface{}{
+		"method": "GET", "path": "/hello?x=1", "status": 200, "peer": "192.0.2.1:1234", "bytes": int64(5),
+	} {
+		if v, _ := r.Lookup(k); v != want {
+			t.Fatalf("Wrong %s: %v, expected %v", k, v, want)
+		}
+	}
+	if _, ok := r.Lookup("dur"); !ok {
+		t.Fatal("Expected duration in record")
+	}
+
+	srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil))
+	if v, _ := r.Lookup("status"); v != 404 || r.Lvl != log.LvlInfo {
+		t.Fatalf("Wrong record for 404: %+v", r)
+	}
+
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Fatalf("Expected panic to be passed on, got %v", p)
+			}
+		}()
+		srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic", nil))
+	}()
+	if v, _ := r.Lookup("status"); v != 500 || r.Lvl != log.LvlError {
+		t.Fatalf("Wrong record for panic: %+v", r)
+	}
+}
+
+func TestRuntimeTraceHandler(t *testing.T) {
+	// not parallel: only one runtime trace can be collected at a time
+	sink := NoopHandler()
+	h := RuntimeTraceHandler(5*time.Millisecond, SlowHandler(10*time.Millisecond, 0, sink))
+
+	var buf bytes.Buffer
+	if err := trace.Start(&buf); err != nil {
+		t.Skip("runtime trace unavailable:", err)
+	}
+	_ = h.Log(&log.Record{Msg: "stalled"})
+	trace.Stop()
+
+	if sink.Count() != 1 {
+		t.Fatalf("Expected record to be written, got %d", sink.Count())
+	}
+	for _, want := range []string{"log.write", "slow log write", "stalled"} {
+		if !bytes.Contains(buf.Bytes(), []byte(want)) {
+			t.Fatalf("Expected %q in trace", want)
+		}
+	}
+}
+
+func TestBufferedFileHandler(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "logext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	read := func(path string) string {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	rec := &log.Record{Msg: "hello", KeyNames: log.RecordKeyNames{Msg: "msg", Lvl: "lvl"}}
+
+	path := dir + "/buffered.log"
+	h, err := BufferedFileHandler(path, log.LogfmtFormat(), 4096, 0, SyncNever)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Log(rec)
+	if s := read(path); s != "" {
+		t.Fatalf("Expected record to be buffered, got %q", s)
+	}
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if s := read(path); s != "lvl=crit msg=hello\n" {
+		t.Fatalf("Wrong file after Flush: %q", s)
+	}
+	h.Log(rec)
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(read(path), "\n"); n != 2 {
+		t.Fatalf("Expected 2 records after Close, got %d", n)
+	}
+
+	path = dir + "/record.log"
+	h, err = BufferedFileHandler(path, log.LogfmtFormat(), 4096, 0, SyncRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Log(rec)
+	if s := read(path); s != "lvl=crit msg=hello\n" {
+		t.Fatalf("Expected record to be written at once, got %q", s)
+	}
+	h.Close()
+
+	path = dir + "/interval.log"
+	h, err = BufferedFileHandler(path, log.LogfmtFormat(), 4096, 10*time.Millisecond, SyncInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	h.Log(rec)
+	for deadline := time.Now().Add(5 * time.Second); read(path) == ""; {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected record to be flushed periodically")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBufferedFileReopen(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "logext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rec := &log.Record{Msg: "hello", KeyNames: log.RecordKeyNames{Msg: "msg", Lvl: "lvl"}}
+	path := dir + "/app.log"
+	h, err := BufferedFileHandler(path, log.LogfmtFormat(), 4096, time.Millisecond, SyncInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Log(rec)
+
+	// rotate the file away, the buffered record still goes to it
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	h.Log(rec)
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatalf("Expected second Close to do nothing, got %v", err)
+	}
+	if err := h.Reopen(); err == nil {
+		t.Fatal("Expected Reopen after Close to fail")
+	}
+
+	for _, p := range []string{path + ".1", path} {
+		if b, err := ioutil.ReadFile(p); err != nil || string(b) != "lvl=crit msg=hello\n" {
+			t.Fatalf("Expected one record in %s, got %q, %v", p, b, err)
+		}
+	}
+}
+
+func TestGapHandler(t *testing.T) {
+	t.Parallel()
+
+	var msgs []string
+	h := GapHandler("seq", log.FuncHandler(func(r *log.Record) error {
+		if r.Msg == "log records lost" {
+			n, _ := r.LookupInt64("missing")
+			msgs = append(msgs, "lost "+strconv.FormatInt(n, 10))
+			return nil
+		}
+		msgs = append(msgs, r.Msg)
+		return nil
+	}))
+	for _, seq := range []uint64{1, 2, 5, 6} {
+		h.Log(&log.Record{Msg: strconv.FormatUint(seq, 10), Ctx: []interface{}{"seq", seq}})
+	}
+	h.Log(&log.Record{Msg: "unnumbered"})
+
+	if fmt.Sprint(msgs) != "[1 2 lost 2 5 6 unnumbered]" {
+		t.Fatalf("Wrong records: %v", msgs)
+	}
+	if h.Missing() != 2 {
+		t.Fatalf("Expected 2 missing records, got %d", h.Missing())
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	t.Parallel()
+
+	b := Backoff{Min: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+	var delays []time.Duration
+	for i := 1; i <= 5; i++ {
+		delays = append(delays, b.Delay(i))
+	}
+	if fmt.Sprint(delays) != "[10ms 20ms 40ms 50ms 50ms]" {
+		t.Fatalf("Wrong delays: %v", delays)
+	}
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := b.Delay(3); d < 20*time.Millisecond || d > 40*time.Millisecond {
+			t.Fatalf("Delay out of jitter range: %v", d)
+		}
+	}
+
+	// without Max the delay saturates instead of overflowing
+	b = Backoff{Min: time.Second}
+	for _, n := range []int{34, 40, 100, 1000} {
+		if d := b.Delay(n); d < b.Delay(33) {
+			t.Fatalf("Delay after %d failures overflowed: %v", n, d)
+		}
+	}
+
+	// the zero policy waits instead of spinning
+	b = Backoff{}
+	if d := b.Delay(1); d != 100*time.Millisecond {
+		t.Fatalf("Expected 100ms for the zero policy, got %v", d)
+	}
+	b.Max = time.Second
+	if d := b.Delay(1000); d != time.Second {
+		t.Fatalf("Expected 1s for Min 0 and Max 1s, got %v", d)
+	}
+}
+
+func TestRetryHandler(t *testing.T) {
+	t.Parallel()
+
+	calls := 0
+	failing := log.FuncHandler(func(r *log.Record) error {
+		calls++
+		if calls < 3 {
+			return ErrInjected
+		}
+		return nil
+	})
+	b := Backoff{Min: time.Millisecond, Max: time.Millisecond}
+	if err := RetryHandler(b, failing).Log(&log.Record{}); err != nil || calls != 3 {
+		t.Fatalf("Expected success on third attempt, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	b.MaxAttempts = 2
+	if err := RetryHandler(b, failing).Log(&log.Record{}); err != ErrInjected || calls != 2 {
+		t.Fatalf("Expected failure after 2 attempts, got %v after %d calls", err, calls)
+	}
+
+	if _, ok := RetryHandler(b, BatchStreamHandler(ioutil.Discard, log.LogfmtFormat())).(BatchHandler); !ok {
+		t.Fatal("Expected retrying batch handler to implement BatchHandler")
+	}
+}
+
+func TestEscalateErrHandlerProvenance(t *testing.T) {
+	t.Parallel()
+
+	h, r := testHandler()
+	l := log.New()
+	l.SetLevel(log.LvlDebug)
+	l.SetHandler(log.ProvenanceHandler(EscalateErrHandler(h)))
+
+	l.Debug("write failed", "err", errors.New("timeout"))
+	if r.Lvl != log.LvlError {
+		t.Fatalf("Expected escalated level, got %v", r.Lvl)
+	}
+	if p, _ := r.LookupString("provenance"); p != "escalate" {
+		t.Fatalf("Expected escalate in provenance, got %q", p)
+	}
+}
+
+type publisher map[string][]string
+
+func (p publisher) Publish(subject string, data []byte) error {
+	p[subject] = append(p[subject], string(data))
+	return nil
+}
+
+func TestPubSubHandler(t *testing.T) {
+	t.Parallel()
+
+	p := publisher{}
+	l := log.New()
+	l.SetTimestamp(false)
+	l.SetHandler(log.MultiHandler(
+		PubSubHandler(p, LevelSubject("logs"), log.LogfmtFormat()),
+		PubSubHandler(p, FieldSubject("tenants", "tenant"), log.LogfmtFormat()),
+	))
+
+	l.Info("hello", "tenant", "acme.corp")
+	l.Error("failed")
+
+	expected := publisher{
+		"logs.info":         {"lvl=info msg=hello tenant=acme.corp"},
+		"logs.eror":         {"lvl=eror msg=failed"},
+		"tenants.acme_corp": {"lvl=info msg=hello tenant=acme.corp"},
+		"tenants":           {"lvl=eror msg=failed"},
+	}
+	if fmt.Sprint(p) != fmt.Sprint(expected) {
+		t.Fatalf("Got %v, expected %v", p, expected)
+	}
+}
+
+func TestHTTPBatchHandler(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu     sync.Mutex
+		bodies []string
+		ctypes []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rd io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			rd = zr
+		}
+		b, _ := ioutil.ReadAll(rd)
+		mu.Lock()
+		bodies = append(bodies, string(b))
+		ctypes = append(ctypes, r.Header.Get("Content-Type"))
+		mu.Unlock()
+		if strings.Contains(string(b), "reject") {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	rs := []*log.Record{
+		{Time: ts, Lvl: log.LvlInfo, Msg: "a", KeyNames: log.RecordKeyNames{Time: "t", Msg: "msg", Lvl: "lvl"}},
+		{Time: ts, Lvl: log.LvlError, Msg: "b", Ctx: []interface{}{"n", 1}, KeyNames: log.RecordKeyNames{Time: "t", Msg: "msg", Lvl: "lvl"}},
+	}
+
+	loki := HTTPBatchHandler(srv.URL, nil, true, LokiPayload(map[string]string{"app": "api"}))
+	if err := loki.LogBatch(rs); err != nil {
+		t.Fatal(err)
+	}
+	var push struct {
+		Streams []struct {
+			Stream map[string]string
+			Values [][2]string
+		}
+	}
+	if err := json.Unmarshal([]byte(bodies[0]), &push); err != nil {
+		t.Fatal(err)
+	}
+	if len(push.Streams) != 2 || push.Streams[0].Stream["level"] != "eror" || push.Streams[0].Stream["app"] != "api" {
+		t.Fatalf("Wrong streams: %+v", push.Streams)
+	}
+	if v := push.Streams[1].Values; len(v) != 1 || v[0][0] != strconv.FormatInt(ts.UnixNano(), 10) || !strings.Contains(v[0][1], `"msg":"a"`) {
+		t.Fatalf("Wrong values: %v", v)
+	}
+
+	es := HTTPBatchHandler(srv.URL, nil, false, ElasticPayload("logs"))
+	if err := es.LogBatch(rs); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(bodies[1], "\n"), "\n")
+	if len(lines) != 4 || lines[0] != `{"index":{"_index":"logs"}}` || !strings.Contains(lines[3], `"message":"b"`) {
+		t.Fatalf("Wrong bulk body: %q", bodies[1])
+	}
+	if ctypes[1] != "application/x-ndjson" {
+		t.Fatalf("Wrong content type: %q", ctypes[1])
+	}
+
+	if err := es.Log(&log.Record{Msg: "reject"}); err == nil {
+		t.Fatal("Expected error for a failed request")
+	}
+}
@@ -0,0 +1,68 @@
+package ext
+
+import (
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/semihalev/log"
+)
+
+// ErrInjected is the error returned by FlakyHandler for the writes it
+// fails on purpose.
+var ErrInjected = errors.New("log: injected failure")
+
+// NoopHandler returns a handler that discards records and only counts
+// them. It is meant for tests and benchmarks, as the destination behind
+// the handlers under test or behind SlowHandler and FlakyHandler:
+//
+//     sink := logext.NoopHandler()
+//     h := logext.AsyncHandler(64, logext.DropNewest,
+//         logext.SlowHandler(10*time.Millisecond, 5*time.Millisecond, sink))
+//
+func NoopHandler() *Noop {
+	return &Noop{}
+}
+
+// Noop is the log.Handler. Read `NoopHandler` for more information.
+type Noop struct {
+	count uint64
+}
+
+// Log implements log.Handler interface
+func (h *Noop) Log(r *log.Record) error {
+	atomic.AddUint64(&h.count, 1)
+	return nil
+}
+
+// Count returns the number of records logged so far.
+func (h *Noop) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+// SlowHandler returns a handler that sleeps for latency plus a random
+// duration of up to jitter before passing each record to h, to simulate a
+// slow destination when testing buffering and backpressure.
+func SlowHandler(latency, jitter time.Duration, h log.Handler) log.Handler {
+	return log.FuncHandler(func(r *log.Record) error {
+		d := latency
+		if jitter > 0 {
+			d += time.Duration(rand.Int63n(int64(jitter)))
+		}
+		time.Sleep(d)
+		return h.Log(r)
+	})
+}
+
+// FlakyHandler returns a handler that fails the given fraction of writes,
+// between 0 and 1, with ErrInjected instead of passing the records to h,
+// to exercise failover and retry logic in tests.
+func FlakyHandler(failRate float64, h log.Handler) log.Handler {
+	return log.FuncHandler(func(r *log.Record) error {
+		if rand.Float64() < failRate {
+			return ErrInjected
+		}
+		return h.Log(r)
+	})
+}
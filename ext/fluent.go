@@ -0,0 +1,277 @@
+package ext
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/semihalev/log"
+)
+
+const fluentTimeout = 10 * time.Second
+
+// FluentHandler returns a handler which sends records to a Fluentd or
+// Fluent Bit in_forward input listening on the given address, using the
+// Forward protocol. Records are sent as msgpack maps under the given tag,
+// with the record's message, level and context as keys and its time as
+// the event time. Group and ObjectMarshaler values become nested maps.
+//
+// Writes time out after ten seconds, so a server that stops reading
+// yields an error instead of blocking forever. If ack is true, every
+// write also asks the server for an acknowledgement and waits up to ten
+// seconds for it, so a nil error means the records were received. After
+// a failed write or ack the connection is closed, so no half-sent
+// message or late ack is left in the stream, and the next batch dials
+// again. The handler implements BatchHandler: a batch is sent as a
+// single Forward mode message.
+func FluentHandler(network, addr, tag string, ack bool) (BatchHandler, error) {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &fluentHandler{network: network, addr: addr, conn: conn, tag: tag, ack: ack}, nil
+}
+
+type fluentHandler struct {
+	mu      sync.Mutex
+	network string
+	addr    string
+	conn    net.Conn
+	tag     string
+	ack     bool
+	buf     []byte
+}
+
+func (h *fluentHandler) Log(r *log.Record) error {
+	return h.LogBatch([]*log.Record{r})
+}
+
+func (h *fluentHandler) LogBatch(rs []*log.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// [tag, [[time, record], ...], option]
+	b := appendMsgpackArray(h.buf[:0], 3)
+	b = appendMsgpackString(b, h.tag)
+	b = appendMsgpackArray(b, len(rs))
+	encode := log.LazyHandler(log.FuncHandler(func(r *log.Record) error {
+		b = appendMsgpackArray(b, 2)
+		b = appendEventTime(b, r.Time)
+		b = appendFluentRecord(b, r)
+		return nil
+	}))
+	for _, r := range rs {
+		_ = encode.Log(r)
+	}
+
+	var chunk string
+	if h.ack {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return err
+		}
+		chunk = base64.StdEncoding.EncodeToString(id)
+		b = appendMsgpackMap(b, 1)
+		b = appendMsgpackString(b, "chunk")
+		b = appendMsgpackString(b, chunk)
+	} else {
+		b = appendMsgpackMap(b, 0)
+	}
+	h.buf = b
+
+	if h.conn == nil {
+		conn, err := net.Dial(h.network, h.addr)
+		if err != nil {
+			return err
+		}
+		h.conn = conn
+	}
+	err := h.send(b, chunk)
+	if err != nil {
+		// the stream may hold part of the message or a late ack
+		h.conn.Close()
+		h.conn = nil
+	}
+	return err
+}
+
+// send writes the message b and waits for the ack of chunk if acks are
+// requested.
+func (h *fluentHandler) send(b []byte, chunk string) error {
+	h.conn.SetWriteDeadline(time.Now().Add(fluentTimeout))
+	if _, err := h.conn.Write(b); err != nil {
+		return err
+	}
+	if !h.ack {
+		return nil
+	}
+
+	// the response is {"ack": chunk}
+	want := appendMsgpackMap(nil, 1)
+	want = appendMsgpackString(want, "ack")
+	want = appendMsgpackString(want, chunk)
+	got := make([]byte, len(want))
+	h.conn.SetReadDeadline(time.Now().Add(fluentTimeout))
+	if _, err := io.ReadFull(h.conn, got); err != nil {
+		return fmt.Errorf("fluent: no ack: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("fluent: unexpected ack %q", got)
+	}
+	return nil
+}
+
+func appendFluentRecord(b []byte, r *log.Record) []byte {
+	b = appendMsgpackMap(b, 2+len(r.Ctx)/2)
+	b = appendMsgpackString(b, r.KeyNames.Msg)
+	b = appendMsgpackString(b, r.Msg)
+	b = appendMsgpackString(b, r.KeyNames.Lvl)
+	b = appendMsgpackString(b, r.Lvl.String())
+	for i := 0; i+1 < len(r.Ctx); i += 2 {
+		b = appendMsgpackString(b, fmt.Sprint(r.Ctx[i]))
+		b = appendMsgpackValue(b, r.Ctx[i+1])
+	}
+	return b
+}
+
+// appendEventTime appends t as a Fluentd EventTime, a msgpack extension
+// of type 0 holding seconds and nanoseconds.
+func appendEventTime(b []byte, t time.Time) []byte {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	b = append(b, 0xd7, 0x00)
+	b = appendUint32(b, uint32(t.Unix()))
+	return appendUint32(b, uint32(t.Nanosecond()))
+}
+
+func appendMsgpackValue(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0)
+	case bool:
+		if v {
+			return append(b, 0xc3)
+		}
+		return append(b, 0xc2)
+	case string:
+		return appendMsgpackString(b, v)
+	case int:
+		return appendMsgpackInt(b, int64(v))
+	case int8:
+		return appendMsgpackInt(b, int64(v))
+	case int16:
+		return appendMsgpackInt(b, int64(v))
+	case int32:
+		return appendMsgpackInt(b, int64(v))
+	case int64:
+		return appendMsgpackInt(b, v)
+	case uint8:
+		return appendMsgpackInt(b, int64(v))
+	case uint16:
+		return appendMsgpackInt(b, int64(v))
+	case uint32:
+		return appendMsgpackInt(b, int64(v))
+	case uint:
+		return appendMsgpackUint(b, uint64(v))
+	case uint64:
+		return appendMsgpackUint(b, v)
+	case float32:
+		return appendMsgpackFloat(b, float64(v))
+	case float64:
+		return appendMsgpackFloat(b, v)
+	case log.Group:
+		b = appendMsgpackMap(b, len(v)/2)
+		for i := 0; i+1 < len(v); i += 2 {
+			b = appendMsgpackString(b, fmt.Sprint(v[i]))
+			b = appendMsgpackValue(b, v[i+1])
+		}
+		return b
+	case log.ObjectMarshaler:
+		return appendMsgpackValue(b, v.MarshalLog())
+	case error:
+		return appendMsgpackString(b, v.Error())
+	default:
+		return appendMsgpackString(b, fmt.Sprintf("%+v", v))
+	}
+}
+
+func appendMsgpackInt(b []byte, n int64) []byte {
+	if n >= 0 && n < 128 {
+		return append(b, byte(n))
+	}
+	if n < 0 && n >= -32 {
+		return append(b, byte(n))
+	}
+	b = append(b, 0xd3)
+	return appendUint64(b, uint64(n))
+}
+
+func appendMsgpackUint(b []byte, n uint64) []byte {
+	if n < 128 {
+		return append(b, byte(n))
+	}
+	b = append(b, 0xcf)
+	return appendUint64(b, n)
+}
+
+func appendMsgpackFloat(b []byte, f float64) []byte {
+	b = append(b, 0xcb)
+	return appendUint64(b, math.Float64bits(f))
+}
+
+func appendMsgpackString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n < 1<<8:
+		b = append(b, 0xd9, byte(n))
+	case n < 1<<16:
+		b = append(b, 0xda, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0xdb)
+		b = appendUint32(b, uint32(n))
+	}
+	return append(b, s...)
+}
+
+func appendMsgpackArray(b []byte, n int) []byte {
+	if n < 16 {
+		return append(b, 0x90|byte(n))
+	}
+	if n < 1<<16 {
+		return append(b, 0xdc, byte(n>>8), byte(n))
+	}
+	b = append(b, 0xdd)
+	return appendUint32(b, uint32(n))
+}
+
+func appendMsgpackMap(b []byte, n int) []byte {
+	if n < 16 {
+		return append(b, 0x80|byte(n))
+	}
+	if n < 1<<16 {
+		return append(b, 0xde, byte(n>>8), byte(n))
+	}
+	b = append(b, 0xdf)
+	return appendUint32(b, uint32(n))
+}
+
+func appendUint32(b []byte, n uint32) []byte {
+	var tmp [4]byte
+	binary.BigEndian.PutUint32(tmp[:], n)
+	return append(b, tmp[:]...)
+}
+
+func appendUint64(b []byte, n uint64) []byte {
+	var tmp [8]byte
+	binary.BigEndian.PutUint64(tmp[:], n)
+	return append(b, tmp[:]...)
+}
@@ -0,0 +1,68 @@
+package ext
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/semihalev/log"
+)
+
+// GapHandler returns a handler that checks the sequence numbers added
+// under key by log.SequenceHandler, and when they skip, because records
+// were dropped on the way, writes a warning to h ahead of the record
+// following the gap, with the number of records lost under "missing".
+// Records without the key are passed on unchecked.
+//
+// It works on records read back with log.Decoder as well, to find losses
+// in a stored log.
+func GapHandler(key string, h log.Handler) *Gap {
+	return &Gap{key: key, handler: h}
+}
+
+// Gap is the log.Handler. Read `GapHandler` for more information.
+type Gap struct {
+	missing uint64
+
+	mu      sync.Mutex
+	key     string
+	last    int64
+	handler log.Handler
+}
+
+// Log implements log.Handler interface
+func (h *Gap) Log(r *log.Record) error {
+	seq, ok := r.LookupInt64(h.key)
+	if !ok {
+		return h.handler.Log(r)
+	}
+
+	h.mu.Lock()
+	last := h.last
+	if seq > last {
+		h.last = seq
+	}
+	h.mu.Unlock()
+
+	if last > 0 && seq > last+1 {
+		n := seq - last - 1
+		atomic.AddUint64(&h.missing, uint64(n))
+		names := r.KeyNames
+		names.Call = ""
+		warn := &log.Record{
+			Time:     r.Time,
+			Lvl:      log.LvlWarn,
+			Msg:      "log records lost",
+			Ctx:      []interface{}{"missing", n, "after", last},
+			KeyNames: names,
+		}
+		if err := h.handler.Log(warn); err != nil {
+			return err
+		}
+	}
+	return h.handler.Log(r)
+}
+
+// Missing returns the number of records found missing so far.
+func (h *Gap) Missing() uint64 {
+	return atomic.LoadUint64(&h.missing)
+}
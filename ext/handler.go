@@ -34,6 +34,7 @@ func EscalateErrHandler(h log.Handler) log.Handler {
 			for i := 1; i < len(r.Ctx); i++ {
 				if v, ok := r.Ctx[i].(error); ok && v != nil {
 					r.Lvl = log.LvlError
+					log.MarkTransformed(r, "escalate")
 					break
 				}
 			}
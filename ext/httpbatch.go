@@ -0,0 +1,153 @@
+package ext
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/semihalev/log"
+)
+
+// A Payload encodes a batch of records into the body of an HTTP request
+// and returns it with its content type.
+type Payload func(rs []*log.Record) (body []byte, contentType string, err error)
+
+// HTTPBatchHandler returns a handler that POSTs records to url, encoded
+// by payload, one request per batch; the body is gzip-compressed if
+// compress is true. A nil client uses http.DefaultClient. Responses
+// other than 2xx are returned as errors.
+//
+// Combine it with AsyncBatchHandler for batching and backpressure and
+// with RetryHandler for retries, e.g. to ship records to Loki:
+//
+//     h := logext.AsyncBatchHandler(4096, logext.Block, 500, time.Second,
+//         logext.RetryHandler(logext.Backoff{Min: time.Second, Max: time.Minute, MaxAttempts: 10},
+//             logext.HTTPBatchHandler("http://loki:3100/loki/api/v1/push", nil, true,
+//                 logext.LokiPayload(map[string]string{"app": "api"}))))
+//
+func HTTPBatchHandler(url string, client *http.Client, compress bool, payload Payload) BatchHandler {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpBatchHandler{url: url, client: client, compress: compress, payload: payload}
+}
+
+type httpBatchHandler struct {
+	url      string
+	client   *http.Client
+	compress bool
+	payload  Payload
+}
+
+func (h *httpBatchHandler) Log(r *log.Record) error {
+	return h.LogBatch([]*log.Record{r})
+}
+
+func (h *httpBatchHandler) LogBatch(rs []*log.Record) error {
+	eval := log.LazyHandler(log.DiscardHandler())
+	for _, r := range rs {
+		_ = eval.Log(r)
+	}
+
+	body, ctype, err := h.payload(rs)
+	if err != nil {
+		return err
+	}
+	if h.compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+
+	req, err := http.NewRequest("POST", h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ctype)
+	if h.compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("http batch: post failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// LokiPayload returns a Payload for the push API of Grafana Loki. Records
+// are sent as JSON lines in one stream per level, labeled with the given
+// labels and "level"; keep labels few and of low cardinality, as Loki
+// indexes them.
+func LokiPayload(labels map[string]string) Payload {
+	fmtr := log.JSONFormatEx(false, false)
+	return func(rs []*log.Record) ([]byte, string, error) {
+		var streams [log.LvlDebug + 1][][2]string
+		for _, r := range rs {
+			if r.Lvl < log.LvlCrit || r.Lvl > log.LvlDebug {
+				continue
+			}
+			t := r.Time
+			if t.IsZero() {
+				t = time.Now()
+			}
+			streams[r.Lvl] = append(streams[r.Lvl], [2]string{
+				strconv.FormatInt(t.UnixNano(), 10),
+				string(fmtr.Format(r)),
+			})
+		}
+
+		var push []interface{}
+		for lvl, values := range streams {
+			if len(values) == 0 {
+				continue
+			}
+			stream := map[string]string{"level": log.Lvl(lvl).String()}
+			for k, v := range labels {
+				stream[k] = v
+			}
+			push = append(push, map[string]interface{}{"stream": stream, "values": values})
+		}
+		body, err := json.Marshal(map[string]interface{}{"streams": push})
+		return body, "application/json", err
+	}
+}
+
+// ElasticPayload returns a Payload for the bulk API of Elasticsearch,
+// indexing every record as a document in ECSFormat into the given index.
+// Elasticsearch reports documents it rejects in the body of a successful
+// response, so such failures do not cause errors.
+func ElasticPayload(index string) Payload {
+	fmtr := log.ECSFormat()
+	action, _ := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": index}})
+	action = append(action, '\n')
+	return func(rs []*log.Record) ([]byte, string, error) {
+		var buf bytes.Buffer
+		for _, r := range rs {
+			buf.Write(action)
+			doc := fmtr.Format(r)
+			buf.Write(doc)
+			if len(doc) == 0 || doc[len(doc)-1] != '\n' {
+				buf.WriteByte('\n')
+			}
+		}
+		return buf.Bytes(), "application/x-ndjson", nil
+	}
+}
@@ -0,0 +1,87 @@
+package ext
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/semihalev/log"
+)
+
+// WatchLevel sets the level of l from the file at path, which holds a
+// level name such as "debug" or "warn", so the verbosity of a running
+// process can be changed by editing the file. The file is read right
+// away, whenever the process receives SIGHUP and, if interval is
+// positive, whenever its modification time changes as checked every
+// interval. A missing file leaves the level alone; unreadable levels are
+// reported on l. Call the returned function to stop watching.
+func WatchLevel(l log.Logger, path string, interval time.Duration) (stop func()) {
+	w := &levelWatcher{l: l, path: path}
+	w.reload()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	stopTick := func() {}
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		tick, stopTick = t.C, t.Stop
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-hup:
+				w.reload()
+			case <-tick:
+				if w.changed() {
+					w.reload()
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(hup)
+		stopTick()
+		close(done)
+	}
+}
+
+type levelWatcher struct {
+	l       log.Logger
+	path    string
+	modTime time.Time
+}
+
+func (w *levelWatcher) changed() bool {
+	fi, err := os.Stat(w.path)
+	return err == nil && !fi.ModTime().Equal(w.modTime)
+}
+
+func (w *levelWatcher) reload() {
+	fi, err := os.Stat(w.path)
+	if err != nil {
+		return
+	}
+	w.modTime = fi.ModTime()
+
+	b, err := ioutil.ReadFile(w.path)
+	if err != nil {
+		w.l.Error("Cannot read level file", "path", w.path, "err", err)
+		return
+	}
+	lvl, err := log.LvlFromString(string(bytes.TrimSpace(b)))
+	if err != nil {
+		w.l.Error("Invalid level file", "path", w.path, "err", err)
+		return
+	}
+	w.l.SetLevel(lvl)
+}
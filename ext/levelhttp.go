@@ -0,0 +1,60 @@
+package ext
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/semihalev/log"
+)
+
+// LevelHTTPHandler returns an http.Handler that lets operators read and
+// change the level of l at runtime, e.g. to switch a service to debug
+// output without redeploying it:
+//
+//     http.Handle("/loglevel", logext.LevelHTTPHandler(log.Root(), nil))
+//
+// GET responds with the current level as {"level":"info"}. PUT sets the
+// level from a body of the same form. The query parameter "logger"
+// selects one of the named loggers instead of l; unknown names get a 404
+// response.
+func LevelHTTPHandler(l log.Logger, named map[string]log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := l
+		if name := r.URL.Query().Get("logger"); name != "" {
+			var ok bool
+			if target, ok = named[name]; !ok {
+				levelError(w, http.StatusNotFound, "unknown logger "+name)
+				return
+			}
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var req struct{ Level string }
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				levelError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			lvl, err := log.LvlFromString(req.Level)
+			if err != nil {
+				levelError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			target.SetLevel(lvl)
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			levelError(w, http.StatusMethodNotAllowed, "only GET and PUT are supported")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"level": target.GetLevel().String()})
+	})
+}
+
+func levelError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
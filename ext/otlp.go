@@ -0,0 +1,200 @@
+package ext
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/semihalev/log"
+)
+
+// OTLPHandler returns a handler that exports records to an OpenTelemetry
+// collector with the OTLP/HTTP protocol in its JSON encoding. url is the
+// logs endpoint of the collector, usually "http://localhost:4318/v1/logs",
+// and resource holds key/value pairs describing the process, such as
+// "service.name". A nil client uses http.DefaultClient.
+//
+// The context keys "trace_id" and "span_id", given as hex strings or as
+// TraceID and SpanID, are sent as the trace context of the log record; all
+// other pairs become its attributes. Each call of Log sends one request, so
+// wrap the handler with AsyncBatchHandler to export records in batches:
+//
+//     h := logext.AsyncBatchHandler(1024, logext.DropOldest, 256, time.Second,
+//         logext.OTLPHandler("http://localhost:4318/v1/logs", nil, "service.name", "api"))
+//
+func OTLPHandler(url string, client *http.Client, resource ...interface{}) BatchHandler {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &otlpHandler{url: url, client: client, resource: otlpAttributes(resource)}
+}
+
+type otlpHandler struct {
+	url      string
+	client   *http.Client
+	resource []otlpKeyValue
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano   string                 `json:"timeUnixNano,omitempty"`
+	SeverityNumber int                    `json:"severityNumber"`
+	SeverityText   string                 `json:"severityText"`
+	Body           map[string]interface{} `json:"body"`
+	Attributes     []otlpKeyValue         `json:"attributes,omitempty"`
+	TraceID        string                 `json:"traceId,omitempty"`
+	SpanID         string                 `json:"spanId,omitempty"`
+}
+
+func (h *otlpHandler) Log(r *log.Record) error {
+	return h.LogBatch([]*log.Record{r})
+}
+
+func (h *otlpHandler) LogBatch(rs []*log.Record) error {
+	recs := make([]otlpLogRecord, 0, len(rs))
+	collect := log.LazyHandler(log.FuncHandler(func(r *log.Record) error {
+		recs = append(recs, otlpRecord(r))
+		return nil
+	}))
+	for _, r := range rs {
+		_ = collect.Log(r)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceLogs": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": h.resource},
+			"scopeLogs": []interface{}{map[string]interface{}{
+				"scope":      map[string]interface{}{"name": "github.com/semihalev/log"},
+				"logRecords": recs,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("otlp: export failed: %s", resp.Status)
+	}
+	return nil
+}
+
+func otlpRecord(r *log.Record) otlpLogRecord {
+	sev := log.OTelSeverities.Lookup(r.Lvl)
+	rec := otlpLogRecord{
+		SeverityNumber: sev.Number,
+		SeverityText:   sev.Name,
+		Body:           otlpValue(r.Msg),
+	}
+	if !r.Time.IsZero() {
+		rec.TimeUnixNano = strconv.FormatInt(r.Time.UnixNano(), 10)
+	}
+
+	var attrs []interface{}
+	for i := 0; i+1 < len(r.Ctx); i += 2 {
+		switch r.Ctx[i] {
+		case "trace_id":
+			if id, ok := otlpID(r.Ctx[i+1]); ok {
+				rec.TraceID = id
+				continue
+			}
+		case "span_id":
+			if id, ok := otlpID(r.Ctx[i+1]); ok {
+				rec.SpanID = id
+				continue
+			}
+		}
+		attrs = append(attrs, r.Ctx[i], r.Ctx[i+1])
+	}
+	rec.Attributes = otlpAttributes(attrs)
+	return rec
+}
+
+// otlpID returns the hex string of a trace or span id given as a string,
+// TraceID or SpanID.
+func otlpID(v interface{}) (string, bool) {
+	switch id := v.(type) {
+	case string:
+		return id, true
+	case TraceID:
+		return id.String(), true
+	case SpanID:
+		return id.String(), true
+	}
+	return "", false
+}
+
+func otlpAttributes(ctx []interface{}) []otlpKeyValue {
+	var kvs []otlpKeyValue
+	for i := 0; i+1 < len(ctx); i += 2 {
+		kvs = append(kvs, otlpKeyValue{fmt.Sprint(ctx[i]), otlpValue(ctx[i+1])})
+	}
+	return kvs
+}
+
+// otlpValue returns the OTLP JSON encoding of an attribute value. 64-bit
+// integers are encoded as strings, as the protobuf JSON mapping requires.
+// Unsigned values above math.MaxInt64 do not fit an intValue and are sent
+// as stringValue.
+func otlpValue(v interface{}) map[string]interface{} {
+	switch v := v.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": v}
+	case bool:
+		return map[string]interface{}{"boolValue": v}
+	case int:
+		return map[string]interface{}{"intValue": strconv.FormatInt(int64(v), 10)}
+	case int8:
+		return map[string]interface{}{"intValue": strconv.FormatInt(int64(v), 10)}
+	case int16:
+		return map[string]interface{}{"intValue": strconv.FormatInt(int64(v), 10)}
+	case int32:
+		return map[string]interface{}{"intValue": strconv.FormatInt(int64(v), 10)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case uint:
+		return otlpValue(uint64(v))
+	case uint8:
+		return map[string]interface{}{"intValue": strconv.FormatUint(uint64(v), 10)}
+	case uint16:
+		return map[string]interface{}{"intValue": strconv.FormatUint(uint64(v), 10)}
+	case uint32:
+		return map[string]interface{}{"intValue": strconv.FormatUint(uint64(v), 10)}
+	case uint64:
+		if v > math.MaxInt64 {
+			// out of range of intValue, which fails the whole request
+			return map[string]interface{}{"stringValue": strconv.FormatUint(v, 10)}
+		}
+		return map[string]interface{}{"intValue": strconv.FormatUint(v, 10)}
+	case float32:
+		return otlpValue(float64(v))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		return map[string]interface{}{"doubleValue": v}
+	case log.Group:
+		return map[string]interface{}{"kvlistValue": map[string]interface{}{"values": otlpAttributes(v)}}
+	case log.ObjectMarshaler:
+		return otlpValue(v.MarshalLog())
+	case error:
+		return map[string]interface{}{"stringValue": v.Error()}
+	default:
+		return map[string]interface{}{"stringValue": fmt.Sprintf("%+v", v)}
+	}
+}
@@ -0,0 +1,59 @@
+package ext
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/semihalev/log"
+)
+
+// Publisher is a message bus client publishing data to a subject or
+// topic. A NATS connection, *nats.Conn, implements it as is; clients of
+// other buses are easily adapted.
+type Publisher interface {
+	Publish(subject string, data []byte) error
+}
+
+// PubSubHandler returns a handler publishing each record, formatted with
+// fmtr, to p under the subject returned by subject, e.g. to stream logs
+// live to dashboards:
+//
+//     nc, _ := nats.Connect(nats.DefaultURL)
+//     h := logext.PubSubHandler(nc, logext.LevelSubject("logs.api"), log.JSONFormat())
+//
+// The trailing newline of the formatted record is not published.
+func PubSubHandler(p Publisher, subject func(r *log.Record) string, fmtr log.Format) log.Handler {
+	return log.LazyHandler(log.FuncHandler(func(r *log.Record) error {
+		b := fmtr.Format(r)
+		if n := len(b); n > 0 && b[n-1] == '\n' {
+			b = b[:n-1]
+		}
+		return p.Publish(subject(r), b)
+	}))
+}
+
+// LevelSubject returns a subject function for PubSubHandler publishing
+// records under prefix followed by their level, such as "logs.eror", so
+// subscribers can pick levels with wildcards.
+func LevelSubject(prefix string) func(r *log.Record) string {
+	return func(r *log.Record) string {
+		return prefix + "." + r.Lvl.String()
+	}
+}
+
+// FieldSubject returns a subject function for PubSubHandler publishing
+// records under prefix followed by the value of the context key, such as
+// "logs.acme" for records with tenant=acme, or under prefix alone for
+// records without the key. Dots, spaces and the wildcards "*" and ">" in
+// the value are replaced with underscores, so the value forms a single
+// subject token.
+func FieldSubject(prefix, key string) func(r *log.Record) string {
+	clean := strings.NewReplacer(".", "_", " ", "_", "*", "_", ">", "_")
+	return func(r *log.Record) string {
+		v, ok := r.Lookup(key)
+		if !ok {
+			return prefix
+		}
+		return prefix + "." + clean.Replace(fmt.Sprint(v))
+	}
+}
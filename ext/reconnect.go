@@ -0,0 +1,199 @@
+package ext
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/semihalev/log"
+)
+
+// ReconnectHandler is like log.NetHandler but survives outages of the
+// remote end. It dials the address on first use, and whenever a write
+// fails it closes the connection and dials again on a later record,
+// backing off between failed attempts as given by DefaultBackoff, or the
+// policy set with SetBackoff.
+//
+// While disconnected, formatted records are kept in memory, up to spill
+// of them, and are sent ahead of new records once the connection is back.
+// When the spill buffer is full the oldest record is dropped; Dropped
+// reports how many were lost. A record whose write failed after part of
+// it was sent is dropped as well, rather than sent again after a torn
+// copy. Records that are kept in the buffer are not reported as errors.
+//
+// Each record is sent with its own write, so on datagram networks such as
+// "udp" and "unixgram" every record is one datagram.
+func ReconnectHandler(network, addr string, spill int, fmtr log.Format) *Reconnect {
+	h := &Reconnect{network: network, addr: addr, max: spill, policy: DefaultBackoff, dial: net.Dial}
+	h.lazy = log.LazyHandler(log.FuncHandler(func(r *log.Record) error {
+		return h.send(fmtr.Format(r))
+	}))
+	return h
+}
+
+// Reconnect is the log.Handler. Read `ReconnectHandler` for more information.
+type Reconnect struct {
+	dropped uint64
+
+	mu       sync.Mutex
+	network  string
+	addr     string
+	lazy     log.Handler
+	dial     func(network, addr string) (net.Conn, error)
+	conn     net.Conn
+	spill    [][]byte
+	max      int
+	policy   Backoff
+	failures int
+	retryAt  time.Time
+	timeout  time.Duration
+}
+
+// Log implements log.Handler interface
+func (h *Reconnect) Log(r *log.Record) error {
+	return h.lazy.Log(r)
+}
+
+// Dropped returns the number of records discarded because the spill
+// buffer was full.
+func (h *Reconnect) Dropped() uint64 {
+	return atomic.LoadUint64(&h.dropped)
+}
+
+// SetBackoff sets the policy for waiting between attempts to reconnect.
+// The handler keeps trying to reconnect for as long as it is used, so
+// MaxAttempts and MaxElapsed are ignored.
+func (h *Reconnect) SetBackoff(b Backoff) {
+	h.mu.Lock()
+	h.policy = b
+	h.mu.Unlock()
+}
+
+// SetWriteTimeout bounds the time a single write may take, so a collector
+// that stops reading cannot block the logging goroutine, or the consumer
+// of an ext.AsyncHandler, forever. A write that times out is handled like
+// any failed write: the connection is dropped and redialed later, and the
+// record is kept in the spill buffer, or dropped and counted if spill is
+// zero. Zero, the default, disables the timeout.
+func (h *Reconnect) SetWriteTimeout(d time.Duration) {
+	h.mu.Lock()
+	h.timeout = d
+	h.mu.Unlock()
+}
+
+// SetDialer replaces net.Dial for making connections, e.g. to wrap them
+// in an authenticated, encrypted transport such as TLS or a Noise
+// session keyed with a pre-shared key:
+//
+//     h.SetDialer(func(network, addr string) (net.Conn, error) {
+//         return tls.Dial(network, addr, &tls.Config{RootCAs: pool})
+//     })
+//
+// The dialer is called on every reconnect, so it may load the current
+// keys each time, and rotated keys take effect on the next connection.
+// The current connection is kept; call Close to make the handler dial
+// again on the next record.
+func (h *Reconnect) SetDialer(dial func(network, addr string) (net.Conn, error)) {
+	h.mu.Lock()
+	h.dial = dial
+	h.mu.Unlock()
+}
+
+// Check dials the address now unless the handler is connected, and
+// returns the error if that fails, so a wrong address is reported at
+// startup rather than by records silently piling up in the spill buffer.
+func (h *Reconnect) Check() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conn != nil {
+		return nil
+	}
+	conn, err := h.dial(h.network, h.addr)
+	if err != nil {
+		h.retry()
+		return err
+	}
+	h.conn = conn
+	h.failures = 0
+	return nil
+}
+
+// Close closes the connection. Records still in the spill buffer are
+// discarded.
+func (h *Reconnect) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.spill = nil
+	if h.conn == nil {
+		return nil
+	}
+	err := h.conn.Close()
+	h.conn = nil
+	return err
+}
+
+func (h *Reconnect) send(b []byte) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.spill = append(h.spill, b)
+	if !h.connect() {
+		h.trim()
+		return nil
+	}
+
+	for len(h.spill) > 0 {
+		if h.timeout > 0 {
+			h.conn.SetWriteDeadline(time.Now().Add(h.timeout))
+		}
+		if n, err := h.conn.Write(h.spill[0]); err != nil {
+			h.conn.Close()
+			h.conn = nil
+			h.retry()
+			if n > 0 {
+				// resending would follow the torn start of the record
+				atomic.AddUint64(&h.dropped, 1)
+				h.spill[0] = nil
+				h.spill = h.spill[1:]
+			}
+			h.trim()
+			return nil
+		}
+		h.spill[0] = nil
+		h.spill = h.spill[1:]
+	}
+	return nil
+}
+
+// connect reports whether there is a connection, dialing if it is time
+// to try again.
+func (h *Reconnect) connect() bool {
+	if h.conn != nil {
+		return true
+	}
+	if time.Now().Before(h.retryAt) {
+		return false
+	}
+	conn, err := h.dial(h.network, h.addr)
+	if err != nil {
+		h.retry()
+		return false
+	}
+	h.conn = conn
+	h.failures = 0
+	return true
+}
+
+func (h *Reconnect) retry() {
+	h.failures++
+	h.retryAt = time.Now().Add(h.policy.Delay(h.failures))
+}
+
+// trim drops the oldest records beyond the spill limit.
+func (h *Reconnect) trim() {
+	if n := len(h.spill) - h.max; n > 0 {
+		atomic.AddUint64(&h.dropped, uint64(n))
+		h.spill = append(h.spill[:0], h.spill[n:]...)
+	}
+}
@@ -0,0 +1,165 @@
+package ext
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/semihalev/log"
+)
+
+// RollupHandler returns a handler that keeps every record of the last
+// window in memory at full fidelity and only writes per-minute roll-ups of
+// older records to the wrapped handler. This bounds the storage needed for
+// long-term logs on appliances and edge devices while keeping recent
+// details available through Recent.
+//
+// Records leaving the window are counted by minute, level and message.
+// Once a minute has completely left the window, one record per level and
+// message of that minute is written to h with the minute as its time and
+// the context keys "count" and "period". Roll-ups are produced as new
+// records arrive; call Flush to roll up everything immediately.
+func RollupHandler(window time.Duration, h log.Handler) *Rollup {
+	return &Rollup{
+		window:  window,
+		handler: h,
+		counts:  make(map[rollupKey]int),
+	}
+}
+
+// Rollup is the log.Handler. Read `RollupHandler` for more information.
+type Rollup struct {
+	mu      sync.Mutex
+	window  time.Duration
+	recent  []rollupEntry
+	counts  map[rollupKey]int
+	handler log.Handler
+}
+
+// rollupEntry is a record of the window with the time it is rolled up
+// by, which is the time the record was logged at or, for records without
+// a timestamp, the time it was received.
+type rollupEntry struct {
+	t time.Time
+	r *log.Record
+}
+
+type rollupKey struct {
+	minute time.Time
+	lvl    log.Lvl
+	msg    string
+}
+
+// Log implements log.Handler interface
+func (h *Rollup) Log(r *log.Record) error {
+	now := r.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	h.mu.Lock()
+	h.recent = append(h.recent, rollupEntry{now, r})
+	recs := h.expire(now.Add(-h.window))
+	h.mu.Unlock()
+
+	return h.write(recs)
+}
+
+// Recent returns the records of the last window.
+func (h *Rollup) Recent() []*log.Record {
+	h.mu.Lock()
+	rollups := h.expire(time.Now().Add(-h.window))
+	recs := make([]*log.Record, len(h.recent))
+	for i, e := range h.recent {
+		recs[i] = e.r
+	}
+	h.mu.Unlock()
+
+	h.write(rollups)
+	return recs
+}
+
+// Flush rolls up all records, including the ones still in the window,
+// and writes the roll-ups to the wrapped handler.
+func (h *Rollup) Flush() error {
+	h.mu.Lock()
+	for _, e := range h.recent {
+		h.count(e)
+	}
+	h.recent = nil
+	recs := h.rollups(func(time.Time) bool { return true })
+	h.mu.Unlock()
+
+	return h.write(recs)
+}
+
+// expire counts the records older than cutoff and returns the roll-ups
+// of the minutes that are complete. It must be called with h.mu held.
+func (h *Rollup) expire(cutoff time.Time) []*log.Record {
+	n := 0
+	for n < len(h.recent) && h.recent[n].t.Before(cutoff) {
+		h.count(h.recent[n])
+		n++
+	}
+	h.recent = append(h.recent[:0], h.recent[n:]...)
+
+	return h.rollups(func(minute time.Time) bool {
+		return !minute.Add(time.Minute).After(cutoff)
+	})
+}
+
+func (h *Rollup) count(e rollupEntry) {
+	key := rollupKey{e.t.Truncate(time.Minute), e.r.Lvl, e.r.Msg}
+	h.counts[key]++
+}
+
+// rollups removes the counts of the minutes accepted by done and returns
+// them as records ordered by minute, level and message.
+func (h *Rollup) rollups(done func(minute time.Time) bool) []*log.Record {
+	var keys []rollupKey
+	for key := range h.counts {
+		if done(key.minute) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if !a.minute.Equal(b.minute) {
+			return a.minute.Before(b.minute)
+		}
+		if a.lvl != b.lvl {
+			return a.lvl < b.lvl
+		}
+		return a.msg < b.msg
+	})
+
+	recs := make([]*log.Record, len(keys))
+	for i, key := range keys {
+		recs[i] = &log.Record{
+			Time: key.minute,
+			Lvl:  key.lvl,
+			Msg:  key.msg,
+			Ctx:  []interface{}{"count", h.counts[key], "period", time.Minute},
+			KeyNames: log.RecordKeyNames{
+				Time: "t",
+				Msg:  "msg",
+				Lvl:  "lvl",
+			},
+		}
+		delete(h.counts, key)
+	}
+	return recs
+}
+
+// write logs recs to the wrapped handler without holding the lock and
+// returns the first error.
+func (h *Rollup) write(recs []*log.Record) error {
+	var err error
+	for _, r := range recs {
+		if e := h.handler.Log(r); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
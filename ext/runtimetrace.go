@@ -0,0 +1,33 @@
+package ext
+
+import (
+	"context"
+	"runtime/trace"
+	"time"
+
+	"github.com/semihalev/log"
+)
+
+// RuntimeTraceHandler wraps h so that while a runtime trace is being
+// collected, e.g. with "go test -trace" or net/http/pprof, each write to h
+// is a "log.write" region of the trace, and writes taking threshold or
+// longer add a "slow log write" event naming the record's message. go tool
+// trace then shows where logging stalls the application. When tracing is
+// off it only costs a check of trace.IsEnabled.
+func RuntimeTraceHandler(threshold time.Duration, h log.Handler) log.Handler {
+	return log.FuncHandler(func(r *log.Record) error {
+		if !trace.IsEnabled() {
+			return h.Log(r)
+		}
+
+		ctx := context.Background()
+		region := trace.StartRegion(ctx, "log.write")
+		start := time.Now()
+		err := h.Log(r)
+		region.End()
+		if d := time.Since(start); d >= threshold {
+			trace.Logf(ctx, "log", "slow log write: %v: %s", d, r.Msg)
+		}
+		return err
+	})
+}
@@ -0,0 +1,101 @@
+package ext
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/semihalev/log"
+)
+
+// TraceparentHeader is the W3C Trace Context header carrying the trace
+// and span id of the caller.
+const TraceparentHeader = "Traceparent"
+
+// TraceID is a W3C trace id. It is logged as 32 lowercase hex digits.
+type TraceID [16]byte
+
+// String returns the hex encoding of the id.
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether id is not all zeros.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// SpanID is a W3C span id. It is logged as 16 lowercase hex digits.
+type SpanID [8]byte
+
+// String returns the hex encoding of the id.
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether id is not all zeros.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// ParseTraceparent returns the trace and span id of a traceparent header
+// value such as "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01".
+func ParseTraceparent(s string) (TraceID, SpanID, error) {
+	var (
+		tid TraceID
+		sid SpanID
+	)
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return tid, sid, errors.New("malformed traceparent")
+	}
+	if _, err := hex.Decode(tid[:], []byte(parts[1])); err != nil {
+		return tid, sid, err
+	}
+	if _, err := hex.Decode(sid[:], []byte(parts[2])); err != nil {
+		return tid, sid, err
+	}
+	if !tid.IsValid() || !sid.IsValid() {
+		return tid, sid, errors.New("invalid traceparent ids")
+	}
+	return tid, sid, nil
+}
+
+type traceKey struct{}
+
+type traceIDs struct {
+	trace TraceID
+	span  SpanID
+}
+
+// ContextWithTrace returns a copy of ctx carrying the given trace and span
+// id, e.g. those parsed from the traceparent header of a request.
+func ContextWithTrace(ctx context.Context, trace TraceID, span SpanID) context.Context {
+	return context.WithValue(ctx, traceKey{}, traceIDs{trace, span})
+}
+
+// TraceFromContext returns the trace and span id stored in ctx by
+// ContextWithTrace.
+func TraceFromContext(ctx context.Context) (TraceID, SpanID, bool) {
+	ids, ok := ctx.Value(traceKey{}).(traceIDs)
+	return ids.trace, ids.span, ok
+}
+
+// TraceCtx returns the trace and span id of ctx as the key/value pairs
+// "trace_id" and "span_id", which formats such as ECSFormat and GCPFormat
+// and OTLPHandler understand. It returns nil if ctx carries no trace.
+func TraceCtx(ctx context.Context) []interface{} {
+	trace, span, ok := TraceFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return []interface{}{"trace_id", trace, "span_id", span}
+}
+
+// TraceLogger returns a child of l carrying the trace and span id of ctx
+// as its context.
+func TraceLogger(l log.Logger, ctx context.Context) log.Logger {
+	return l.New(TraceCtx(ctx)...)
+}
@@ -2,13 +2,18 @@ package log
 
 import (
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 const (
@@ -46,40 +51,253 @@ func (f formatFunc) Format(r *Record) []byte {
 //     [May 16 20:58:45] [DBUG] remove route ns=haproxy addr=127.0.0.1:50002
 //
 func TerminalFormat() Format {
-	return FormatFunc(func(r *Record) []byte {
-		var color = 0
-		switch r.Lvl {
-		case LvlCrit:
-			color = 35
-		case LvlError:
-			color = 31
-		case LvlWarn:
-			color = 33
-		case LvlInfo:
-			color = 32
-		case LvlDebug:
-			color = 36
+	return TerminalFormatWith(TerminalOptions{})
+}
+
+// TerminalFormatEx is like TerminalFormat, but if prettyFields is true it
+// prints each pair on its own indented line under the message, and
+// pretty-prints values holding JSON, with bytes that are not JSON shown
+// as a hex dump, for reading records while developing:
+//
+//     [INFO] [05-16|20:58:45] request served
+//         path: /users
+//         body: {
+//                 "name": "gopher"
+//             }
+//
+func TerminalFormatEx(prettyFields bool) Format {
+	return TerminalFormatWith(TerminalOptions{PrettyFields: prettyFields})
+}
+
+// TerminalOptions customizes the output of TerminalFormatWith. The zero
+// value formats records as TerminalFormat does.
+type TerminalOptions struct {
+	// TimeFormat is the layout of timestamps, "01-02|15:04:05" if empty.
+	TimeFormat string
+
+	// NoColor disables the escape codes that color levels and keys.
+	// Otherwise they are always written, whether or not the output is a
+	// terminal.
+	NoColor bool
+
+	// Levels replaces the names levels are printed with, e.g. "E" for
+	// LvlError. Levels missing from it keep their default name.
+	Levels map[Lvl]string
+
+	// MsgWidth is the width messages are padded to, so the pairs of
+	// records with short messages line up. Zero means 40, and a negative
+	// width disables padding.
+	MsgWidth int
+
+	// KeyOrder lists the keys written first, in this order. The other
+	// pairs follow in the order they were logged.
+	KeyOrder []string
+
+	// Theme sets the colors and styles of the output, the ANSI colors of
+	// TerminalFormat if it is the zero Theme.
+	Theme Theme
+
+	// Colors replaces the ANSI color codes of levels in the theme, such
+	// as 31 for red, which color the level name and the keys of records
+	// at that level.
+	Colors map[Lvl]int
+
+	// KeyColors gives keys a color of their own, to highlight them in
+	// records of any level.
+	KeyColors map[string]int
+
+	// PrettyFields prints each pair on its own line, as described for
+	// TerminalFormatEx.
+	PrettyFields bool
+}
+
+// A Theme styles the parts of records written by TerminalFormatWith with
+// the parameters of ANSI SGR escape codes, such as "31" for red, "1;31"
+// for bold red, "38;5;208" for orange from the 256-color palette or
+// "38;2;255;128;0" for a truecolor orange. Parts without parameters are
+// not styled.
+type Theme struct {
+	// Levels styles the level name and the keys of records by level.
+	Levels map[Lvl]string
+
+	// Messages styles messages by level, e.g. to make errors bold.
+	Messages map[Lvl]string
+
+	// Time and Caller style the timestamp and the caller.
+	Time   string
+	Caller string
+}
+
+// Built-in themes
+var (
+	// DarkTheme uses 256 colors that read well on dark backgrounds, with
+	// bold messages for errors and dim timestamps.
+	DarkTheme = Theme{
+		Levels: map[Lvl]string{
+			LvlCrit:  "1;38;5;201",
+			LvlError: "38;5;196",
+			LvlWarn:  "38;5;214",
+			LvlInfo:  "38;5;42",
+			LvlDebug: "38;5;39",
+		},
+		Messages: map[Lvl]string{LvlCrit: "1", LvlError: "1"},
+		Time:     "38;5;245",
+		Caller:   "2",
+	}
+
+	// LightTheme is like DarkTheme, with darker colors for light
+	// backgrounds.
+	LightTheme = Theme{
+		Levels: map[Lvl]string{
+			LvlCrit:  "1;38;5;127",
+			LvlError: "38;5;160",
+			LvlWarn:  "38;5;130",
+			LvlInfo:  "38;5;28",
+			LvlDebug: "38;5;25",
+		},
+		Messages: map[Lvl]string{LvlCrit: "1", LvlError: "1"},
+		Time:     "38;5;242",
+		Caller:   "2",
+	}
+
+	// MonochromeTheme tells levels apart by style only, for terminals
+	// without colors.
+	MonochromeTheme = Theme{
+		Levels: map[Lvl]string{
+			LvlCrit:  "1;7",
+			LvlError: "1",
+			LvlWarn:  "4",
+			LvlInfo:  "0",
+			LvlDebug: "2",
+		},
+		Messages: map[Lvl]string{LvlCrit: "1", LvlError: "1"},
+		Caller:   "2",
+	}
+)
+
+var termTheme = Theme{
+	Levels: map[Lvl]string{
+		LvlCrit:  "35",
+		LvlError: "31",
+		LvlWarn:  "33",
+		LvlInfo:  "32",
+		LvlDebug: "36",
+	},
+	Caller: "2",
+}
+
+// TerminalFormatWith is like TerminalFormat, formatting records as set by
+// opts:
+//
+//     log.StreamHandler(os.Stderr, log.TerminalFormatWith(log.TerminalOptions{
+//         TimeFormat: time.Kitchen,
+//         KeyOrder:   []string{"reqid"},
+//         Theme:      log.DarkTheme,
+//     }))
+//
+func TerminalFormatWith(opts TerminalOptions) Format {
+	timeFormat := opts.TimeFormat
+	if timeFormat == "" {
+		timeFormat = termTimeFormat
+	}
+	msgJust := opts.MsgWidth
+	if msgJust == 0 {
+		msgJust = termMsgJust
+	}
+
+	theme := opts.Theme
+	if theme.Levels == nil && theme.Messages == nil && theme.Time == "" && theme.Caller == "" {
+		theme = termTheme
+	}
+	if len(opts.Colors) > 0 {
+		levels := make(map[Lvl]string, len(theme.Levels)+len(opts.Colors))
+		for lvl, c := range theme.Levels {
+			levels[lvl] = c
+		}
+		for lvl, c := range opts.Colors {
+			levels[lvl] = strconv.Itoa(c)
 		}
+		theme.Levels = levels
+	}
+	keyColors := make(map[string]string, len(opts.KeyColors))
+	for k, c := range opts.KeyColors {
+		keyColors[k] = strconv.Itoa(c)
+	}
+	if opts.NoColor {
+		theme, keyColors = Theme{}, nil
+	}
+
+	return FormatFunc(func(r *Record) []byte {
+		color := theme.Levels[r.Lvl]
 
 		b := &bytes.Buffer{}
-		lvl := strings.ToUpper(r.Lvl.String())
-		if color > 0 {
-			fmt.Fprintf(b, "\x1b[%dm%s\x1b[0m[%s] %s ", color, lvl, r.Time.Format(termTimeFormat), r.Msg)
+		lvl, ok := opts.Levels[r.Lvl]
+		if !ok {
+			lvl = strings.ToUpper(r.Lvl.String())
+		}
+		if !opts.NoColor {
+			sgr(b, color, lvl)
 		} else {
-			fmt.Fprintf(b, "[%s] [%s] %s ", lvl, r.Time.Format(termTimeFormat), r.Msg)
+			fmt.Fprintf(b, "[%s] ", lvl)
+		}
+		if !r.Time.IsZero() {
+			b.WriteByte('[')
+			sgr(b, theme.Time, r.Time.Format(timeFormat))
+			b.WriteString("] ")
+		} else if !opts.NoColor {
+			b.WriteByte(' ')
+		}
+		if r.KeyNames.Call != "" {
+			sgr(b, theme.Caller, callerString(r.Call))
+			b.WriteByte(' ')
+		}
+		msg := theme.Messages[r.Lvl]
+
+		ctx := r.Ctx
+		if len(opts.KeyOrder) > 0 {
+			ctx = orderKeys(ctx, opts.KeyOrder)
 		}
+		if opts.PrettyFields {
+			sgr(b, msg, r.Msg)
+			b.WriteByte('\n')
+			prettyfmt(b, ctx, color, keyColors)
+			return b.Bytes()
+		}
+		sgr(b, msg, r.Msg)
+		b.WriteByte(' ')
 
 		// try to justify the log output for short messages
-		if len(r.Ctx) > 0 && len(r.Msg) < termMsgJust {
-			b.Write(bytes.Repeat([]byte{' '}, termMsgJust-len(r.Msg)))
+		if len(ctx) > 0 && len(r.Msg) < msgJust {
+			b.Write(bytes.Repeat([]byte{' '}, msgJust-len(r.Msg)))
 		}
 
 		// print the keys logfmt style
-		logfmt(b, r.Ctx, color)
+		logfmt(b, ctx, color, keyColors)
 		return b.Bytes()
 	})
 }
 
+// orderKeys returns a copy of ctx with the pairs of the given keys moved
+// to the front, in the order of keys.
+func orderKeys(ctx []interface{}, keys []string) []interface{} {
+	ordered := make([]interface{}, 0, len(ctx))
+	moved := make([]bool, len(ctx)/2)
+	for _, k := range keys {
+		for i := 0; i+1 < len(ctx); i += 2 {
+			if !moved[i/2] && ctx[i] == k {
+				ordered = append(ordered, ctx[i], ctx[i+1])
+				moved[i/2] = true
+			}
+		}
+	}
+	for i := 0; i+1 < len(ctx); i += 2 {
+		if !moved[i/2] {
+			ordered = append(ordered, ctx[i], ctx[i+1])
+		}
+	}
+	return ordered
+}
+
 // LogfmtFormat prints records in logfmt format, an easy machine-parseable but human-readable
 // format for key/value pairs.
 //
@@ -88,13 +306,20 @@ func TerminalFormat() Format {
 func LogfmtFormat() Format {
 	return FormatFunc(func(r *Record) []byte {
 		common := []interface{}{r.KeyNames.Time, r.Time, r.KeyNames.Lvl, r.Lvl, r.KeyNames.Msg, r.Msg}
+		if r.Time.IsZero() {
+			common = common[2:]
+		}
+		if r.KeyNames.Call != "" {
+			common = append(common, r.KeyNames.Call, callerString(r.Call))
+		}
 		buf := &bytes.Buffer{}
-		logfmt(buf, append(common, r.Ctx...), 0)
+		logfmt(buf, append(common, r.Ctx...), "", nil)
 		return buf.Bytes()
 	})
 }
 
-func logfmt(buf *bytes.Buffer, ctx []interface{}, color int) {
+func logfmt(buf *bytes.Buffer, ctx []interface{}, color string, keyColors map[string]string) {
+	ctx = flattenGroups("", ctx)
 	for i := 0; i < len(ctx); i += 2 {
 		if i != 0 {
 			buf.WriteByte(' ')
@@ -107,18 +332,84 @@ func logfmt(buf *bytes.Buffer, ctx []interface{}, color int) {
 		}
 
 		// XXX: we should probably check that all of your key bytes aren't invalid
-		if color > 0 {
-			fmt.Fprintf(buf, "\x1b[%dm%s\x1b[0m=%s", color, k, v)
-		} else {
-			buf.WriteString(k)
-			buf.WriteByte('=')
-			buf.WriteString(v)
-		}
+		sgr(buf, keyColor(k, color, keyColors), k)
+		buf.WriteByte('=')
+		buf.WriteString(v)
 	}
 
 	buf.WriteByte('\n')
 }
 
+func prettyfmt(buf *bytes.Buffer, ctx []interface{}, color string, keyColors map[string]string) {
+	ctx = flattenGroups("", ctx)
+	for i := 0; i+1 < len(ctx); i += 2 {
+		k, ok := ctx[i].(string)
+		v := formatPrettyValue(ctx[i+1])
+		if !ok {
+			k, v = errorKey, formatLogfmtValue(ctx[i])
+		}
+
+		buf.WriteString("    ")
+		sgr(buf, keyColor(k, color, keyColors), k)
+		buf.WriteByte(':')
+		for j, line := range strings.Split(strings.TrimSuffix(v, "\n"), "\n") {
+			switch {
+			case j > 0:
+				buf.WriteString("\n        ")
+			case line != "":
+				buf.WriteByte(' ')
+			}
+			buf.WriteString(line)
+		}
+		buf.WriteByte('\n')
+	}
+}
+
+func keyColor(k, color string, keyColors map[string]string) string {
+	if c, ok := keyColors[k]; ok {
+		return c
+	}
+	return color
+}
+
+// sgr writes s styled with the SGR parameters, or as is if there are none.
+func sgr(buf *bytes.Buffer, params, s string) {
+	if params == "" {
+		buf.WriteString(s)
+		return
+	}
+	fmt.Fprintf(buf, "\x1b[%sm%s\x1b[0m", params, s)
+}
+
+// formatPrettyValue formats a value for TerminalFormatEx with pretty
+// fields: JSON in strings and bytes is indented, other bytes are hex
+// dumped, and strings are written as they are.
+func formatPrettyValue(value interface{}) string {
+	var b []byte
+	switch v := value.(type) {
+	case []byte:
+		b = v
+	case json.RawMessage:
+		b = v
+	case string:
+		if t := strings.TrimSpace(v); t == "" || t[0] != '{' && t[0] != '[' {
+			return v
+		}
+		b = []byte(v)
+	default:
+		return formatLogfmtValue(value)
+	}
+
+	var out bytes.Buffer
+	if json.Indent(&out, b, "", "    ") == nil {
+		return out.String()
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return "\n" + hex.Dump(b)
+}
+
 // JSONFormat formats log records as JSON objects separated by newlines.
 // It is the equivalent of JsonFormatEx(false, true).
 func JSONFormat() Format {
@@ -139,9 +430,14 @@ func JSONFormatEx(pretty, lineSeparated bool) Format {
 	return FormatFunc(func(r *Record) []byte {
 		props := make(map[string]interface{})
 
-		props[r.KeyNames.Time] = r.Time
+		if !r.Time.IsZero() {
+			props[r.KeyNames.Time] = r.Time
+		}
 		props[r.KeyNames.Lvl] = r.Lvl.String()
 		props[r.KeyNames.Msg] = r.Msg
+		if r.KeyNames.Call != "" {
+			props[r.KeyNames.Call] = callerString(r.Call)
+		}
 
 		for i := 0; i < len(r.Ctx); i += 2 {
 			k, ok := r.Ctx[i].(string)
@@ -167,6 +463,154 @@ func JSONFormatEx(pretty, lineSeparated bool) Format {
 	})
 }
 
+// ECSFormat formats log records as Elastic Common Schema JSON documents
+// separated by newlines, so that they can be indexed by Elastic without
+// ingest pipelines. The record's time, level, message and call site are
+// written as "@timestamp", "log.level", "message" and "log.origin", and
+// the context keys "trace_id", "span_id" and "err" as "trace.id", "span.id"
+// and "error.message". Dotted keys are nested, so "http.status" becomes
+// {"http": {"status": ...}}.
+func ECSFormat() Format {
+	return FormatFunc(func(r *Record) []byte {
+		doc := map[string]interface{}{
+			"ecs": map[string]interface{}{"version": ecsVersion},
+		}
+
+		if !r.Time.IsZero() {
+			doc["@timestamp"] = r.Time.UTC().Format(time.RFC3339Nano)
+		}
+		nestKey(doc, "log.level", ECSSeverities.Lookup(r.Lvl).Name)
+		doc["message"] = r.Msg
+		if r.KeyNames.Call != "" {
+			nestKey(doc, "log.origin.file.name", fmt.Sprintf("%s", r.Call))
+			nestKey(doc, "log.origin.file.line", r.Call.Frame().Line)
+			nestKey(doc, "log.origin.function", fmt.Sprintf("%n", r.Call))
+		}
+
+		for i := 0; i < len(r.Ctx); i += 2 {
+			k, ok := r.Ctx[i].(string)
+			if !ok {
+				doc[errorKey] = fmt.Sprintf("%+v is not a string key", r.Ctx[i])
+				continue
+			}
+			if name, ok := ecsFields[k]; ok {
+				k = name
+			}
+			nestKey(doc, k, formatJSONValue(r.Ctx[i+1]))
+		}
+
+		b, err := json.Marshal(doc)
+		if err != nil {
+			b, _ = json.Marshal(map[string]string{
+				errorKey: err.Error(),
+			})
+			return b
+		}
+		return append(b, '\n')
+	})
+}
+
+const ecsVersion = "1.6.0"
+
+// ecsFields maps common context keys to their ECS field names.
+var ecsFields = map[string]string{
+	"trace_id": "trace.id",
+	"span_id":  "span.id",
+	"err":      "error.message",
+	"error":    "error.message",
+}
+
+// nestKey stores v in m under the dotted key, creating nested objects for
+// each dot. If a part of the path already holds a plain value, v is
+// stored under the full dotted key instead, and if the key names an
+// object, such as "log", v is stored in the "labels" object, so fields
+// like log.level are never overwritten.
+func nestKey(m map[string]interface{}, key string, v interface{}) {
+	parts := strings.Split(key, ".")
+	obj := m
+	for _, p := range parts[:len(parts)-1] {
+		switch child := obj[p].(type) {
+		case map[string]interface{}:
+			obj = child
+		case nil:
+			next := make(map[string]interface{})
+			obj[p] = next
+			obj = next
+		default:
+			m[key] = v
+			return
+		}
+	}
+	if _, ok := obj[parts[len(parts)-1]].(map[string]interface{}); ok {
+		labels, ok := m["labels"].(map[string]interface{})
+		if !ok {
+			if m["labels"] != nil {
+				m["labels."+key] = v
+				return
+			}
+			labels = make(map[string]interface{})
+			m["labels"] = labels
+		}
+		labels[key] = v
+		return
+	}
+	obj[parts[len(parts)-1]] = v
+}
+
+// GCPFormat formats log records as the structured JSON that Google Cloud
+// Logging agents, e.g. on GKE and Cloud Run, read from standard output.
+// The record's level is written as "severity", its time as "time", its
+// message as "message" and its call site, when captured, as
+// "logging.googleapis.com/sourceLocation". The context keys "trace_id"
+// and "span_id" are written as "logging.googleapis.com/trace" and
+// "logging.googleapis.com/spanId" so records are correlated with Cloud
+// Trace; projectID is the project the traces belong to.
+func GCPFormat(projectID string) Format {
+	return FormatFunc(func(r *Record) []byte {
+		props := map[string]interface{}{
+			"severity": GCPSeverities.Lookup(r.Lvl).Name,
+			"message":  r.Msg,
+		}
+
+		if !r.Time.IsZero() {
+			props["time"] = r.Time.UTC().Format(time.RFC3339Nano)
+		}
+		if r.KeyNames.Call != "" {
+			props["logging.googleapis.com/sourceLocation"] = map[string]interface{}{
+				"file":     fmt.Sprintf("%+s", r.Call),
+				"line":     strconv.Itoa(r.Call.Frame().Line),
+				"function": fmt.Sprintf("%+n", r.Call),
+			}
+		}
+
+		for i := 0; i < len(r.Ctx); i += 2 {
+			k, ok := r.Ctx[i].(string)
+			if !ok {
+				props[errorKey] = fmt.Sprintf("%+v is not a string key", r.Ctx[i])
+				continue
+			}
+			v := formatJSONValue(r.Ctx[i+1])
+			switch k {
+			case "trace_id":
+				props["logging.googleapis.com/trace"] = fmt.Sprintf("projects/%s/traces/%v", projectID, v)
+			case "span_id":
+				props["logging.googleapis.com/spanId"] = v
+			default:
+				props[k] = v
+			}
+		}
+
+		b, err := json.Marshal(props)
+		if err != nil {
+			b, _ = json.Marshal(map[string]string{
+				errorKey: err.Error(),
+			})
+			return b
+		}
+		return append(b, '\n')
+	})
+}
+
 func formatShared(value interface{}) (result interface{}) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -194,13 +638,112 @@ func formatShared(value interface{}) (result interface{}) {
 }
 
 func formatJSONValue(value interface{}) interface{} {
+	if g, ok := asGroup(value); ok {
+		obj := make(map[string]interface{}, len(g)/2)
+		for i := 0; i+1 < len(g); i += 2 {
+			obj[fmt.Sprint(g[i])] = formatJSONValue(g[i+1])
+		}
+		return obj
+	}
 	value = formatShared(value)
 	switch value.(type) {
-	case int, int8, int16, int32, int64, float32, float64, uint, uint8, uint16, uint32, uint64, string:
+	case nil, bool, int, int8, int16, int32, int64, float32, float64, uint, uint8, uint16, uint32, uint64, string:
 		return value
-	default:
-		return fmt.Sprintf("%+v", value)
 	}
+
+	switch v := reflect.ValueOf(value); {
+	case v.Kind() == reflect.Struct || v.Kind() == reflect.Ptr && v.Type().Elem().Kind() == reflect.Struct:
+		// structs keep their JSON encoding, honoring their field tags
+		if b, err := json.Marshal(value); err == nil {
+			return json.RawMessage(b)
+		}
+	case isList(v):
+		arr := make([]interface{}, v.Len())
+		for i := range arr {
+			arr[i] = formatJSONValue(v.Index(i).Interface())
+		}
+		return arr
+	case v.Kind() == reflect.Map:
+		obj := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			obj[fmt.Sprint(k.Interface())] = formatJSONValue(v.MapIndex(k).Interface())
+		}
+		return obj
+	}
+	return fmt.Sprintf("%+v", value)
+}
+
+// isList reports whether v is a slice or array other than a byte slice.
+func isList(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice:
+		return v.Type().Elem().Kind() != reflect.Uint8
+	case reflect.Array:
+		return true
+	}
+	return false
+}
+
+// formatList formats slices and arrays as [a,b,c] and maps as {k=v,...}
+// with sorted keys, for logfmt output. It reports false for other values.
+func formatList(value interface{}) (string, bool) {
+	v := reflect.ValueOf(value)
+	switch {
+	case isList(v):
+		elems := make([]string, v.Len())
+		for i := range elems {
+			elems[i] = formatElem(v.Index(i).Interface())
+		}
+		return "[" + strings.Join(elems, ",") + "]", true
+	case v.Kind() == reflect.Map:
+		elems := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			elems = append(elems, formatElem(k.Interface())+"="+formatElem(v.MapIndex(k).Interface()))
+		}
+		sort.Strings(elems)
+		return "{" + strings.Join(elems, ",") + "}", true
+	}
+	return "", false
+}
+
+func formatElem(value interface{}) string {
+	if value == nil {
+		return "nil"
+	}
+	if s, ok := formatList(value); ok {
+		return s
+	}
+	return fmt.Sprintf("%+v", formatShared(value))
+}
+
+// flattenGroups returns ctx with the pairs of Group and ObjectMarshaler
+// values inlined under dotted keys, prefixed with prefix. ctx is returned
+// as is if it holds no groups.
+func flattenGroups(prefix string, ctx []interface{}) []interface{} {
+	grouped := prefix != ""
+	for i := 1; i < len(ctx) && !grouped; i += 2 {
+		switch ctx[i].(type) {
+		case Group, ObjectMarshaler:
+			grouped = true
+		}
+	}
+	if !grouped {
+		return ctx
+	}
+
+	flat := make([]interface{}, 0, len(ctx))
+	for i := 0; i+1 < len(ctx); i += 2 {
+		k := ctx[i]
+		if s, ok := k.(string); ok && prefix != "" {
+			k = prefix + s
+		}
+		if g, ok := asGroup(ctx[i+1]); ok {
+			flat = append(flat, flattenGroups(fmt.Sprint(k)+".", g)...)
+			continue
+		}
+		flat = append(flat, k, ctx[i+1])
+	}
+	return flat
 }
 
 // formatValue formats a value for serialization
@@ -228,6 +771,9 @@ func formatLogfmtValue(value interface{}) string {
 	case string:
 		return escapeString(v)
 	default:
+		if s, ok := formatList(value); ok {
+			return escapeString(s)
+		}
 		return escapeString(fmt.Sprintf("%+v", value))
 	}
 }
@@ -236,14 +782,31 @@ var stringBufPool = sync.Pool{
 	New: func() interface{} { return new(bytes.Buffer) },
 }
 
+// maxPooledBuf is the capacity above which buffers are not returned to
+// stringBufPool, so one huge value does not pin its buffer for good.
+var maxPooledBuf int64 = 64 << 10
+
+// SetMaxPooledBuffer sets the capacity above which the buffers used to
+// escape values are dropped after use instead of being kept for reuse.
+// The default of 64KB suits most records; raise it for services logging
+// larger values, so their buffers are not allocated again for every
+// record. Zero or less disables reuse.
+func SetMaxPooledBuffer(n int) {
+	atomic.StoreInt64(&maxPooledBuf, int64(n))
+}
+
+// escapeString quotes s for logfmt output if it holds spaces, '=' or '"',
+// escaping quotes, backslashes and control characters, the latter as
+// \uXXXX unless they have a short escape. Invalid UTF-8 bytes are written
+// as \ufffd, as encoding/json does.
 func escapeString(s string) string {
 	needsQuotes := false
 	needsEscape := false
 	for _, r := range s {
-		if r <= ' ' || r == '=' || r == '"' {
+		if r <= ' ' || r == '=' || r == '"' || unicode.IsControl(r) || r == utf8.RuneError {
 			needsQuotes = true
 		}
-		if r == '\\' || r == '"' || r == '\n' || r == '\r' || r == '\t' {
+		if r == '\\' || r == '"' || unicode.IsControl(r) || r == utf8.RuneError {
 			needsEscape = true
 		}
 	}
@@ -252,17 +815,23 @@ func escapeString(s string) string {
 	}
 	e := stringBufPool.Get().(*bytes.Buffer)
 	e.WriteByte('"')
-	for _, r := range s {
-		switch r {
-		case '\\', '"':
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		switch {
+		case r == '\\', r == '"':
 			e.WriteByte('\\')
 			e.WriteByte(byte(r))
-		case '\n':
+		case r == '\n':
 			e.WriteString("\\n")
-		case '\r':
+		case r == '\r':
 			e.WriteString("\\r")
-		case '\t':
+		case r == '\t':
 			e.WriteString("\\t")
+		case r == utf8.RuneError && size == 1:
+			e.WriteString(`\ufffd`)
+		case unicode.IsControl(r):
+			fmt.Fprintf(e, "\\u%04x", r)
 		default:
 			e.WriteRune(r)
 		}
@@ -274,7 +843,9 @@ func escapeString(s string) string {
 	} else {
 		ret = string(e.Bytes()[1 : e.Len()-1])
 	}
-	e.Reset()
-	stringBufPool.Put(e)
+	if int64(e.Cap()) <= atomic.LoadInt64(&maxPooledBuf) {
+		e.Reset()
+		stringBufPool.Put(e)
+	}
 	return ret
 }
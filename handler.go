@@ -97,7 +97,30 @@ func (h *closingHandler) Close() error {
 // the calling function to the context with key "caller".
 func CallerFileHandler(h Handler) Handler {
 	return FuncHandler(func(r *Record) error {
-		r.Ctx = append(r.Ctx, "caller", fmt.Sprint(r.Call))
+		r.Ctx = append(r.Ctx, "caller", callerString(r.Call))
+		return h.Log(r)
+	})
+}
+
+// SequenceHandler returns a Handler that adds a sequence number, counting
+// the records passing through it from 1, to the context with the given
+// key. Put it in front of handlers that may drop records, such as
+// ext.AsyncHandler, and ext.GapHandler behind them to report losses:
+//
+//     h := log.SequenceHandler("seq", logext.AsyncHandler(1024, logext.DropNewest,
+//         logext.GapHandler("seq", log.StderrHandler)))
+//
+// Records are passed to h one at a time, in the order of their numbers.
+func SequenceHandler(key string, h Handler) Handler {
+	var (
+		mu  sync.Mutex
+		seq uint64
+	)
+	return FuncHandler(func(r *Record) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seq++
+		r.Ctx = append(r.Ctx, key, seq)
 		return h.Log(r)
 	})
 }
@@ -188,22 +211,110 @@ func LvlFilterHandler(maxLvl Lvl, h Handler) Handler {
 	}, h)
 }
 
+// RenameHandler returns a Handler that renames the keys of records before
+// passing them to the wrapped handler, so output matches the schema a
+// downstream system expects without changing call sites. names maps old
+// keys to new ones and applies to the record keys, such as "msg" and
+// "lvl", as well as to the context keys. For example:
+//
+//     log.RenameHandler(map[string]string{"msg": "message", "lvl": "severity"},
+//         log.StreamHandler(os.Stdout, log.JSONFormat()))
+//
+// The wrapped handler gets a copy of the record, so handlers next to
+// RenameHandler in a MultiHandler still see the original keys.
+func RenameHandler(names map[string]string, h Handler) Handler {
+	return FuncHandler(func(r *Record) error {
+		renamed := false
+		rename := func(k string) string {
+			if n, ok := names[k]; ok {
+				renamed = true
+				return n
+			}
+			return k
+		}
+
+		rr := *r
+		rr.KeyNames = RecordKeyNames{
+			Time: rename(r.KeyNames.Time),
+			Msg:  rename(r.KeyNames.Msg),
+			Lvl:  rename(r.KeyNames.Lvl),
+			Call: rename(r.KeyNames.Call),
+		}
+		rr.Ctx = make([]interface{}, len(r.Ctx))
+		copy(rr.Ctx, r.Ctx)
+		for i := 0; i < len(rr.Ctx); i += 2 {
+			if k, ok := rr.Ctx[i].(string); ok {
+				rr.Ctx[i] = rename(k)
+			}
+		}
+		if renamed {
+			MarkTransformed(&rr, "rename")
+		}
+		return h.Log(&rr)
+	})
+}
+
+const provenanceKey = "provenance"
+
+// ProvenanceHandler enables provenance tracking for the records it passes
+// to h: it adds the context key "provenance", to which the handlers behind
+// it that change records, such as RenameHandler and
+// ext.EscalateErrHandler, append their names, e.g.
+//
+//     provenance=rename,escalate
+//
+// so auditors can tell which stages of a pipeline touched a record. The
+// provenance of records no stage changed is empty. Place it in front of
+// all transforming handlers; h gets a copy of the record.
+func ProvenanceHandler(h Handler) Handler {
+	return FuncHandler(func(r *Record) error {
+		rr := *r
+		rr.Ctx = make([]interface{}, len(r.Ctx), len(r.Ctx)+2)
+		copy(rr.Ctx, r.Ctx)
+		rr.Ctx = append(rr.Ctx, provenanceKey, "")
+		return h.Log(&rr)
+	})
+}
+
+// MarkTransformed notes in the provenance of r that the named stage
+// changed it. Handlers that modify records should call it; it does
+// nothing for records without provenance tracking, see ProvenanceHandler.
+func MarkTransformed(r *Record, stage string) {
+	for i := len(r.Ctx) - 2; i >= 0; i -= 2 {
+		if r.Ctx[i] != provenanceKey {
+			continue
+		}
+		if p, ok := r.Ctx[i+1].(string); ok {
+			if p != "" {
+				stage = p + "," + stage
+			}
+			r.Ctx[i+1] = stage
+		}
+		return
+	}
+}
+
 // MultiHandler dispatches any write to each of its handlers.
 // This is useful for writing different types of log information
-// to different locations. For example, to log to a file and
-// standard error:
+// to different locations. For example, to log everything to a file
+// and only warnings and errors to standard error:
 //
 //     log.MultiHandler(
 //         log.Must.FileHandler("/var/log/app.log", log.LogfmtFormat()),
-//         log.StderrHandler)
+//         log.LvlFilterHandler(log.LvlWarn, log.StderrHandler))
 //
+// A failing handler does not keep the record from the others. The
+// first error encountered is returned once all handlers have been
+// written to.
 func MultiHandler(hs ...Handler) Handler {
 	return FuncHandler(func(r *Record) error {
+		var err error
 		for _, h := range hs {
-			// what to do about failures?
-			h.Log(r)
+			if e := h.Log(r); e != nil && err == nil {
+				err = e
+			}
 		}
-		return nil
+		return err
 	})
 }
 
@@ -330,6 +441,48 @@ func DiscardHandler() Handler {
 	})
 }
 
+// A Middleware wraps a Handler in another one, such as a filter or a
+// buffer. Any wrapping handler of this package is turned into one with a
+// closure:
+//
+//     func(next log.Handler) log.Handler { return log.LvlFilterHandler(log.LvlInfo, next) }
+type Middleware func(next Handler) Handler
+
+// Compose returns a Middleware applying the given ones in order: records
+// pass through the first middleware, then the second, and so on, before
+// reaching the handler the composition wraps.
+//
+// Wrapping handlers combine predictably when they are stacked in this
+// order, from the logger towards the destination:
+//
+//  1. filters, such as LvlFilterHandler and FilterHandler, so dropped
+//     records cost as little as possible;
+//  2. handlers rewriting records, such as RenameHandler and the Caller
+//     handlers, so later stages only see what will be written;
+//  3. sampling and aggregation, such as ext.RollupHandler;
+//  4. buffering and batching, such as BufferedHandler and
+//     ext.AsyncBatchHandler, which hand records to another goroutine;
+//  5. the transport, such as StreamHandler, NetHandler or
+//     ext.ReconnectHandler.
+//
+// For example:
+//
+//     mw := log.Compose(
+//         func(next log.Handler) log.Handler { return log.LvlFilterHandler(log.LvlInfo, next) },
+//         func(next log.Handler) log.Handler { return log.RenameHandler(names, next) },
+//         func(next log.Handler) log.Handler { return logext.AsyncHandler(1024, logext.DropOldest, next) },
+//     )
+//     log.Root().SetHandler(mw(log.Must.NetHandler("tcp", addr, log.JSONFormat())))
+//
+func Compose(mws ...Middleware) Middleware {
+	return func(next Handler) Handler {
+		for i := len(mws) - 1; i >= 0; i-- {
+			next = mws[i](next)
+		}
+		return next
+	}
+}
+
 // Must object provides the following Handler creation functions
 // which instead of returning an error parameter only return a Handler
 // and panic on failure: FileHandler, NetHandler, SyslogHandler, SyslogNetHandler
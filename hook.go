@@ -0,0 +1,90 @@
+package log
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Hook is called by a Logger for every record that passes the level and
+// sampling checks, before the record is handed to the Logger's handler.
+// Hooks see the record's level, message and context and may modify any of
+// them, e.g. to redact values or count errors. Returning false vetoes the
+// record and it is not written.
+//
+// Lazy values in the context have not been evaluated yet when hooks run.
+type Hook interface {
+	Fire(r *Record) bool
+}
+
+// HookFunc returns a Hook that runs the given function.
+func HookFunc(fn func(r *Record) bool) Hook {
+	return hookFunc(fn)
+}
+
+type hookFunc func(r *Record) bool
+
+func (f hookFunc) Fire(r *Record) bool {
+	return f(r)
+}
+
+// hooks is a copy-on-write list of hooks so loggers can read it without
+// locking. It holds the subscriptions of a logger, which descendants read
+// through their parent.
+type hooks struct {
+	mu   sync.Mutex
+	list atomic.Value // []Hook
+}
+
+func (hs *hooks) add(h Hook) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	old, _ := hs.list.Load().([]Hook)
+	list := make([]Hook, len(old), len(old)+1)
+	copy(list, old)
+	hs.list.Store(append(list, h))
+}
+
+func (hs *hooks) remove(h Hook) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	old, _ := hs.list.Load().([]Hook)
+	list := make([]Hook, 0, len(old))
+	for _, o := range old {
+		if o != h {
+			list = append(list, o)
+		}
+	}
+	hs.list.Store(list)
+}
+
+func (hs *hooks) get() []Hook {
+	list, _ := hs.list.Load().([]Hook)
+	return list
+}
+
+// fire runs all hooks in order and reports whether the record should be
+// written.
+func (hs *hooks) fire(r *Record) bool {
+	for _, h := range hs.get() {
+		if !h.Fire(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// subscription is the Hook behind Logger.Subscribe. It is always a pointer
+// so it can be found again when the subscription is cancelled.
+type subscription struct {
+	filter func(r *Record) bool
+	fn     func(r Record)
+}
+
+func (s *subscription) Fire(r *Record) bool {
+	if s.filter == nil || s.filter(r) {
+		rec := *r
+		rec.Ctx = append([]interface{}(nil), r.Ctx...)
+		s.fn(rec)
+	}
+	return true
+}
@@ -3,15 +3,23 @@ package log
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
+	"os"
 	"regexp"
 	"runtime"
+	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/go-stack/stack"
 )
 
 func testHandler() (Handler, *Record) {
@@ -115,6 +123,601 @@ func TestJson(t *testing.T) {
 	validate("lvl", "eror")
 }
 
+func TestGroup(t *testing.T) {
+	t.Parallel()
+
+	l, buf := testFormatter(LogfmtFormat())
+	l.Info("served", "http", Group{"method", "GET", "resp", Group{"status", 200}}, "n", 1)
+	if !strings.HasSuffix(buf.String(), "msg=served http.method=GET http.resp.status=200 n=1\n") {
+		t.Fatalf("Wrong logfmt output: %q", buf.String())
+	}
+
+	l, buf = testFormatter(JSONFormat())
+	l.Info("served", "http", Group{"method", "GET", "resp", Group{"status", 200}})
+	var v struct {
+		HTTP struct {
+			Method string
+			Resp   struct{ Status int }
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
+		t.Fatalf("Error decoding JSON: %v", err)
+	}
+	if v.HTTP.Method != "GET" || v.HTTP.Resp.Status != 200 {
+		t.Fatalf("Wrong nested group: %s", buf.String())
+	}
+}
+
+type testUser struct {
+	id   int
+	name string
+}
+
+func (u testUser) MarshalLog() Group {
+	return Group{"id", u.id, "name", u.name}
+}
+
+func TestObjectMarshaler(t *testing.T) {
+	t.Parallel()
+
+	l, buf := testFormatter(LogfmtFormat())
+	l.Info("login", "user", testUser{7, "bob"})
+	if !strings.HasSuffix(buf.String(), "msg=login user.id=7 user.name=bob\n") {
+		t.Fatalf("Wrong logfmt output: %q", buf.String())
+	}
+
+	l, buf = testFormatter(JSONFormat())
+	l.Info("login", "user", testUser{7, "bob"})
+	if !strings.Contains(buf.String(), `"user":{"id":7,"name":"bob"}`) {
+		t.Fatalf("Wrong JSON output: %s", buf.String())
+	}
+}
+
+func TestListValues(t *testing.T) {
+	t.Parallel()
+
+	l, buf := testFormatter(LogfmtFormat())
+	l.Info("test", "tags", []string{"a", "b"}, "ids", []int{1, 2, 3}, "env", map[string]string{"b": "2", "a": "1"})
+	if !strings.HasSuffix(buf.String(), `tags=[a,b] ids=[1,2,3] env="{a=1,b=2}"`+"\n") {
+		t.Fatalf("Wrong logfmt output: %q", buf.String())
+	}
+
+	l, buf = testFormatter(JSONFormat())
+	l.Info("test", "tags", []string{"a", "b"}, "ids", []int{1, 2, 3}, "env", map[string]string{"a": "1"})
+	var v struct {
+		Tags []string
+		IDs  []int
+		Env  map[string]string
+	}
+	if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
+		t.Fatalf("Error decoding JSON: %v", err)
+	}
+	if fmt.Sprint(v.Tags, v.IDs, v.Env) != "[a b] [1 2 3] map[a:1]" {
+		t.Fatalf("Wrong JSON output: %s", buf.String())
+	}
+}
+
+func TestJSONTypes(t *testing.T) {
+	t.Parallel()
+
+	type point struct {
+		X int `json:"x"`
+		Y int `json:"y"`
+	}
+	l, buf := testFormatter(JSONFormat())
+	l.Info("test", "ok", true, "none", nil, "p", point{1, 2}, "pp", &point{3, 4}, "err", errors.New("boom"))
+
+	var v struct {
+		OK   *bool
+		None *int
+		P    point
+		PP   point
+		Err  string
+	}
+	if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
+		t.Fatalf("Error decoding JSON %s: %v", buf.String(), err)
+	}
+	if v.OK == nil || !*v.OK || v.None != nil || v.P != (point{1, 2}) || v.PP != (point{3, 4}) || v.Err != "boom" {
+		t.Fatalf("Wrong JSON output: %s", buf.String())
+	}
+}
+
+func TestECSFormat(t *testing.T) {
+	t.Parallel()
+
+	l, buf := testFormatter(ECSFormat())
+	l.Error("some message", "trace_id", "abc", "http.status", 500, "http.method", "GET")
+
+	var v struct {
+		Timestamp string `json:"@timestamp"`
+		Message   string
+		Log       struct{ Level string }
+		Trace     struct{ ID string }
+		HTTP      struct {
+			Status int
+			Method string
+		}
+		ECS struct{ Version string }
+	}
+	if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
+		t.Fatalf("Error decoding JSON: %v", err)
+	}
+	if v.Timestamp == "" || v.Message != "some message" || v.Log.Level != "error" || v.ECS.Version == "" {
+		t.Fatalf("Wrong ECS base fields: %+v", v)
+	}
+	if v.Trace.ID != "abc" || v.HTTP.Status != 500 || v.HTTP.Method != "GET" {
+		t.Fatalf("Wrong nested fields: %+v", v)
+	}
+
+	// keys naming an object go to labels instead of replacing it
+	buf.Reset()
+	l.Info("clash", "log", "x", "ecs", 1)
+	var c struct {
+		Log    struct{ Level string }
+		ECS    struct{ Version string }
+		Labels map[string]interface{}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &c); err != nil {
+		t.Fatalf("Error decoding JSON: %v", err)
+	}
+	if c.Log.Level != "info" || c.ECS.Version == "" || c.Labels["log"] != "x" || c.Labels["ecs"] != 1.0 {
+		t.Fatalf("Wrong fields for clashing keys: %+v", c)
+	}
+}
+
+func TestGCPFormat(t *testing.T) {
+	t.Parallel()
+
+	l, buf := testFormatter(GCPFormat("my-project"))
+	l.Warn("some message", "trace_id", "abc", "span_id", "def", "x", 1)
+
+	var v map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
+		t.Fatalf("Error decoding JSON: %v", err)
+	}
+	for key, expected := range map[string]interface{}{
+		"severity":                      "WARNING",
+		"message":                       "some message",
+		"logging.googleapis.com/trace":  "projects/my-project/traces/abc",
+		"logging.googleapis.com/spanId": "def",
+		"x":                             float64(1),
+	} {
+		if v[key] != expected {
+			t.Fatalf("Got %v expected %v for %v", v[key], expected, key)
+		}
+	}
+	if _, ok := v["time"].(string); !ok {
+		t.Fatalf("Expected time in record: %v", v)
+	}
+}
+
+func TestSeverityMap(t *testing.T) {
+	t.Parallel()
+
+	m := SeverityMap{LvlCrit: {"fatal", 1}, LvlWarn: {"warn", 4}}
+	for lvl, expected := range map[Lvl]string{
+		LvlCrit:      "fatal",
+		LvlError:     "fatal",
+		LvlWarn:      "warn",
+		LvlDebug:     "warn",
+		LvlDebug + 1: "warn",
+	} {
+		if got := m.Lookup(lvl).Name; got != expected {
+			t.Fatalf("Expected %s for level %d, got %s", expected, lvl, got)
+		}
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		line string
+		lvl  Lvl
+		msg  string
+		time time.Time
+		ctx  []interface{}
+	}{
+		{ // zap production encoder
+			line: `{"level":"warn","ts":1588000000.5,"caller":"app/main.go:12","msg":"slow","ms":1200,"ratio":0.5}`,
+			lvl:  LvlWarn,
+			msg:  "slow",
+			time: time.Unix(1588000000, 5e8),
+			ctx:  []interface{}{"caller", "app/main.go:12", "ms", int64(1200), "ratio", 0.5},
+		},
+		{ // zerolog default
+			line: `{"level":"fatal","service":"api","time":"2020-04-27T15:06:40Z","message":"down"}`,
+			lvl:  LvlCrit,
+			msg:  "down",
+			time: time.Date(2020, 4, 27, 15, 6, 40, 0, time.UTC),
+			ctx:  []interface{}{"service", "api"},
+		},
+		{ // this package
+			line: `{"lvl":"eror","msg":"failed","t":"2020-04-27T15:06:40+0000","ok":false}`,
+			lvl:  LvlError,
+			msg:  "failed",
+			time: time.Date(2020, 4, 27, 15, 6, 40, 0, time.UTC),
+			ctx:  []interface{}{"ok", false},
+		},
+	}
+
+	for _, tt := range tests {
+		r, err := ParseJSON([]byte(tt.line))
+		if err != nil {
+			t.Fatalf("ParseJSON(%s): %v", tt.line, err)
+		}
+		if r.Lvl != tt.lvl || r.Msg != tt.msg || !r.Time.Equal(tt.time) {
+			t.Fatalf("ParseJSON(%s) = lvl %v msg %q time %v", tt.line, r.Lvl, r.Msg, r.Time)
+		}
+		if fmt.Sprint(r.Ctx) != fmt.Sprint(tt.ctx) {
+			t.Fatalf("ParseJSON(%s) ctx = %v, expected %v", tt.line, r.Ctx, tt.ctx)
+		}
+	}
+
+	if _, err := ParseJSON([]byte(`["not", "an", "object"]`)); err == nil {
+		t.Fatalf("Expected error parsing non-object")
+	}
+}
+
+func TestParseRFC5424(t *testing.T) {
+	t.Parallel()
+
+	msg := `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 ` +
+		`[exampleSDID@32473 iut="3" eventSource="Application"][meta note="a \"quoted\" \]"] ` +
+		"\xef\xbb\xbfAn application event log entry\n"
+
+	r, err := ParseRFC5424([]byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Lvl != LvlInfo {
+		t.Fatalf("Wrong level, got %v expected %v", r.Lvl, LvlInfo)
+	}
+	if !r.Time.Equal(time.Date(2003, 10, 11, 22, 14, 15, 3e6, time.UTC)) {
+		t.Fatalf("Wrong time, got %v", r.Time)
+	}
+	if r.Msg != "An application event log entry" {
+		t.Fatalf("Wrong message, got %q", r.Msg)
+	}
+
+	expected := []interface{}{"facility", 20, "host", "mymachine.example.com", "app", "evntslog",
+		"msgid", "ID47", "exampleSDID@32473.iut", "3", "exampleSDID@32473.eventSource", "Application",
+		"meta.note", `a "quoted" ]`}
+	if fmt.Sprint(r.Ctx) != fmt.Sprint(expected) {
+		t.Fatalf("Wrong context, got %v expected %v", r.Ctx, expected)
+	}
+
+	for _, bad := range []string{"no priority", "<999>1 - - - - - -", "<14>2 - - - - - -", "<14>1 - - - - - [broken"} {
+		if _, err := ParseRFC5424([]byte(bad)); err == nil {
+			t.Fatalf("Expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	t.Parallel()
+
+	l, buf := testFormatter(JSONFormat())
+	l.Info("first", "n", 1, "name", "a")
+	buf.WriteString("\n")
+	buf.WriteString(`{"level":"warn","message":"second","ok":true,"ratio":0.25}` + "\n")
+	buf.WriteString("not json\n")
+
+	d := NewDecoder(buf)
+	r, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Msg != "first" || r.Lvl != LvlInfo || r.Time.IsZero() {
+		t.Fatalf("Wrong first record: %+v", r)
+	}
+	if n, ok := r.LookupInt64("n"); !ok || n != 1 {
+		t.Fatalf("Wrong value for n, got %v", n)
+	}
+	if s, ok := r.LookupString("name"); !ok || s != "a" {
+		t.Fatalf("Wrong value for name, got %v", s)
+	}
+
+	r, err = d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := r.LookupBool("ok"); r.Msg != "second" || !ok || !b {
+		t.Fatalf("Wrong second record: %+v", r)
+	}
+	if f, ok := r.LookupFloat64("ratio"); !ok || f != 0.25 {
+		t.Fatalf("Wrong value for ratio, got %v", f)
+	}
+
+	if _, err = d.Decode(); err == nil || !strings.Contains(err.Error(), "line 4") {
+		t.Fatalf("Expected error on line 4, got %v", err)
+	}
+	if _, err = d.Decode(); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+}
+
+func TestDecoderSkipInvalid(t *testing.T) {
+	t.Parallel()
+
+	d := NewDecoder(strings.NewReader(`{"lvl":"info","msg":"json"}
+<11>1 2020-04-27T15:00:00Z host app - - - syslog
+not a record
+"lvl":"in` + "\x00\x00" + `{"lvl":"warn","msg":"resynced"}
+`))
+	d.SkipInvalid(true)
+
+	var msgs []string
+	for {
+		r, err := d.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, r.Msg)
+	}
+	if fmt.Sprint(msgs) != "[json syslog resynced]" {
+		t.Fatalf("Wrong records: %v", msgs)
+	}
+	if d.Skipped() != 1 {
+		t.Fatalf("Expected 1 skipped line, got %d", d.Skipped())
+	}
+}
+
+func TestDecoderPartialLine(t *testing.T) {
+	t.Parallel()
+
+	// a file being written to, read while the record is half done
+	var file bytes.Buffer
+	file.WriteString(`{"lvl":"info","ms`)
+	d := NewDecoder(&file)
+	d.Follow(true)
+	if _, err := d.Decode(); err != io.EOF {
+		t.Fatalf("Expected io.EOF for a partial line, got %v", err)
+	}
+	if d.Offset() != 0 {
+		t.Fatalf("Expected offset to stay at 0, got %d", d.Offset())
+	}
+
+	file.WriteString(`g":"done"}` + "\n")
+	r, err := d.Decode()
+	if err != nil || r.Msg != "done" {
+		t.Fatalf("Expected completed record, got %v, %v", r, err)
+	}
+	if d.Offset() != 28 {
+		t.Fatalf("Expected offset 28, got %d", d.Offset())
+	}
+
+	// without Follow a complete file's last line is its last record
+	d = NewDecoder(strings.NewReader(`{"lvl":"info","msg":"a"}` + "\n" + `{"lvl":"info","msg":"b"}`))
+	for _, msg := range []string{"a", "b"} {
+		if r, err := d.Decode(); err != nil || r.Msg != msg {
+			t.Fatalf("Expected %q, got %v, %v", msg, r, err)
+		}
+	}
+	if _, err := d.Decode(); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+	if d.Offset() != 49 {
+		t.Fatalf("Expected offset 49, got %d", d.Offset())
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/app.log.offset"
+
+	if off, err := ReadCheckpoint(path); err != nil || off != 0 {
+		t.Fatalf("Expected offset 0 without checkpoint, got %d, %v", off, err)
+	}
+
+	stream := `{"lvl":"info","msg":"first"}` + "\n" + `{"lvl":"info","msg":"second"}` + "\n"
+	d := NewDecoder(strings.NewReader(stream))
+	if _, err := d.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteCheckpoint(path, d.Offset()); err != nil {
+		t.Fatal(err)
+	}
+
+	// resume after a restart
+	off, err := ReadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewDecoder(strings.NewReader(stream[off:])).Decode()
+	if err != nil || r.Msg != "second" {
+		t.Fatalf("Expected to resume at second record, got %v, %v", r, err)
+	}
+}
+
+func TestEncryptingWriter(t *testing.T) {
+	t.Parallel()
+
+	key := bytes.Repeat([]byte{7}, 32)
+	var enc bytes.Buffer
+	w, err := NewEncryptingWriter(&enc, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New()
+	l.SetHandler(StreamHandler(w, JSONFormat()))
+	l.Info("secret", "card", "4111")
+	l.Warn("second")
+	if bytes.Contains(enc.Bytes(), []byte("secret")) {
+		t.Fatal("Expected record to be encrypted")
+	}
+
+	r, err := NewDecryptingReader(bytes.NewReader(enc.Bytes()), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDecoder(r)
+	for _, msg := range []string{"secret", "second"} {
+		rec, err := d.Decode()
+		if err != nil || rec.Msg != msg {
+			t.Fatalf("Expected %q, got %v, %v", msg, rec, err)
+		}
+	}
+	if _, err := d.Decode(); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+
+	// a wrong key or an altered frame fails
+	r, _ = NewDecryptingReader(bytes.NewReader(enc.Bytes()), bytes.Repeat([]byte{8}, 32))
+	if _, err := r.Read(make([]byte, 1)); err != ErrDecrypt {
+		t.Fatalf("Expected ErrDecrypt with wrong key, got %v", err)
+	}
+	r, _ = NewDecryptingReader(bytes.NewReader(enc.Bytes()[:enc.Len()-1]), key)
+	if _, err := ioutil.ReadAll(r); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Expected io.ErrUnexpectedEOF for a torn frame, got %v", err)
+	}
+}
+
+func TestEncryptingWriterFrames(t *testing.T) {
+	t.Parallel()
+
+	key := bytes.Repeat([]byte{7}, 32)
+	var enc bytes.Buffer
+	w, _ := NewEncryptingWriter(&enc, key)
+	var frames [][]byte
+	for _, s := range []string{"a", "b", "c"} {
+		n := enc.Len()
+		w.Write([]byte(s))
+		frames = append(frames, append([]byte(nil), enc.Bytes()[n:]...))
+	}
+	// a second writer appending to the same file
+	w2, _ := NewEncryptingWriter(&enc, key)
+	w2.Write([]byte("d"))
+
+	r, _ := NewDecryptingReader(bytes.NewReader(enc.Bytes()), key)
+	if data, err := ioutil.ReadAll(r); err != nil || string(data) != "abcd" {
+		t.Fatalf("Expected abcd, got %q, %v", data, err)
+	}
+
+	// dropped or reordered frames fail
+	for _, order := range [][]int{{0, 2}, {0, 2, 1}, {1, 2}} {
+		var data []byte
+		for _, i := range order {
+			data = append(data, frames[i]...)
+		}
+		r, _ := NewDecryptingReader(bytes.NewReader(data), key)
+		if _, err := ioutil.ReadAll(r); err != ErrDecrypt {
+			t.Fatalf("Expected ErrDecrypt for frames %v, got %v", order, err)
+		}
+	}
+
+	// writes longer than maxFrame are split
+	enc.Reset()
+	w, _ = NewEncryptingWriter(&enc, key)
+	p := make([]byte, maxFrame+1)
+	if n, err := w.Write(p); n != len(p) || err != nil {
+		t.Fatalf("Expected %d bytes written, got %d, %v", len(p), n, err)
+	}
+	if size := binary.BigEndian.Uint32(enc.Bytes()); size != maxFrame {
+		t.Fatalf("Expected a frame of %d bytes, got %d", maxFrame, size)
+	}
+	r, _ = NewDecryptingReader(bytes.NewReader(enc.Bytes()), key)
+	if data, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(data, p) {
+		t.Fatalf("Expected %d bytes read, got %d, %v", len(p), len(data), err)
+	}
+}
+
+func TestCompressingWriter(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	w, err := NewCompressingWriter(&buf, gzip.BestSpeed, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New()
+	l.SetHandler(StreamHandler(w, JSONFormat()))
+
+	decode := func(data []byte) []string {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msgs []string
+		d := NewDecoder(zr)
+		for {
+			r, err := d.Decode()
+			if err != nil {
+				return msgs
+			}
+			msgs = append(msgs, r.Msg)
+		}
+	}
+
+	l.Info("first")
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	l.Info("second")
+	// a stream cut short is readable up to the last flush
+	if msgs := decode(buf.Bytes()); fmt.Sprint(msgs) != "[first]" {
+		t.Fatalf("Expected flushed record, got %v", msgs)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if msgs := decode(buf.Bytes()); fmt.Sprint(msgs) != "[first second]" {
+		t.Fatalf("Expected all records after Close, got %v", msgs)
+	}
+}
+
+func TestRecordUnmarshal(t *testing.T) {
+	t.Parallel()
+
+	line := `{"t":"2020-01-02T03:04:05Z","lvl":"warn","msg":"done","user_id":42,"ratio":0.5,` +
+		`"took":"1.5s","ok":true,"started":"2020-01-02T03:04:00Z","skipped":"x","big":300}`
+	r, err := ParseJSON([]byte(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v struct {
+		Time    time.Time     `log:"t"`
+		Lvl     Lvl           `log:"lvl"`
+		Msg     string        `log:"msg"`
+		UserID  uint16        `log:"user_id"`
+		Ratio   float32       `log:"ratio"`
+		Took    time.Duration `log:"took"`
+		OK      bool          `log:"ok"`
+		Started time.Time     `log:"started"`
+		Skipped string        `log:"-"`
+		Missing int           `log:"missing"`
+		Big     int8          `log:"big"`
+	}
+	err = r.Unmarshal(&v)
+	if uerr, ok := err.(*UnmarshalError); !ok || uerr.Key != "big" {
+		t.Fatalf("Expected UnmarshalError for big, got %v", err)
+	}
+	if !v.Time.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) || v.Lvl != LvlWarn || v.Msg != "done" {
+		t.Fatalf("Wrong record fields: %+v", v)
+	}
+	if v.UserID != 42 || v.Ratio != 0.5 || v.Took != 1500*time.Millisecond || !v.OK {
+		t.Fatalf("Wrong context fields: %+v", v)
+	}
+	if v.Started.Minute() != 4 || v.Skipped != "" || v.Missing != 0 || v.Big != 0 {
+		t.Fatalf("Wrong context fields: %+v", v)
+	}
+
+	if err := r.Unmarshal(v); err == nil {
+		t.Fatal("Expected error for non-pointer")
+	}
+}
+
 type testtype struct {
 	name string
 }
@@ -140,12 +743,314 @@ func TestLogfmt(t *testing.T) {
 	}
 }
 
+func TestLogfmtUnicode(t *testing.T) {
+	t.Parallel()
+
+	l, buf := testFormatter(LogfmtFormat())
+	l.SetTimestamp(false)
+	l.Info("ünïcode", "city", "İstanbul", "emoji", "a b 🙂", "ctrl", "a\x00b\x1b[31m", "invalid", "a\xffb")
+
+	expected := `lvl=info msg=ünïcode city=İstanbul emoji="a b 🙂" ctrl="a\u0000b\u001b[31m" invalid="a\ufffdb"` + "\n"
+	if got := buf.String(); got != expected {
+		t.Fatalf("Got %s, expected %s", got, expected)
+	}
+}
+
+func TestMaxPooledBuffer(t *testing.T) {
+	// not parallel: changes the pool limit
+	defer SetMaxPooledBuffer(64 << 10)
+
+	large := strings.Repeat("a b", 100<<10)
+	SetMaxPooledBuffer(1 << 20)
+	escapeString(large)
+	b := stringBufPool.Get().(*bytes.Buffer)
+	if b.Cap() < len(large) {
+		t.Fatalf("Expected the large buffer to be reused, got capacity %d", b.Cap())
+	}
+
+	SetMaxPooledBuffer(0)
+	stringBufPool.Put(b)
+	escapeString("a b")
+	if b := stringBufPool.Get().(*bytes.Buffer); b.Cap() != 0 {
+		t.Fatalf("Expected no buffer to be reused, got capacity %d", b.Cap())
+	}
+}
+
+func TestTerminalPrettyFields(t *testing.T) {
+	t.Parallel()
+
+	l, buf := testFormatter(TerminalFormatEx(true))
+	l.SetTimestamp(false)
+	l.Error("failed", "path", "/users", "body", `{"name":"gopher"}`, "raw", []byte{0, 'a'}, "text", "a\nb")
+
+	key := func(k string) string { return "    \x1b[31m" + k + "\x1b[0m:" }
+	expected := "\x1b[31mEROR\x1b[0m failed\n" +
+		key("path") + " /users\n" +
+		key("body") + " {\n            \"name\": \"gopher\"\n        }\n" +
+		key("raw") + "\n        00000000  00 61                                             |.a|\n" +
+		key("text") + " a\n        b\n"
+	if got := buf.String(); got != expected {
+		t.Fatalf("Got %q, expected %q", got, expected)
+	}
+}
+
+func TestTerminalOptions(t *testing.T) {
+	t.Parallel()
+
+	l, buf := testFormatter(TerminalFormatWith(TerminalOptions{
+		TimeFormat: "--",
+		NoColor:    true,
+		Levels:     map[Lvl]string{LvlWarn: "W"},
+		MsgWidth:   6,
+		KeyOrder:   []string{"id", "missing"},
+	}))
+	l.Warn("hi", "x", 1, "id", 7)
+
+	expected := "[W] [--] hi     id=7 x=1\n"
+	if got := buf.String(); got != expected {
+		t.Fatalf("Got %q, expected %q", got, expected)
+	}
+
+	l, buf = testFormatter(TerminalFormatWith(TerminalOptions{
+		Colors:    map[Lvl]int{LvlInfo: 34},
+		MsgWidth:  -1,
+		KeyColors: map[string]int{"err": 91},
+	}))
+	l.SetTimestamp(false)
+	l.Info("hi", "x", 1, "err", "boom")
+
+	expected = "\x1b[34mINFO\x1b[0m hi \x1b[34mx\x1b[0m=1 \x1b[91merr\x1b[0m=boom\n"
+	if got := buf.String(); got != expected {
+		t.Fatalf("Got %q, expected %q", got, expected)
+	}
+}
+
+func TestSequenceHandler(t *testing.T) {
+	t.Parallel()
+
+	h, r := testHandler()
+	l := New()
+	l.SetHandler(SequenceHandler("seq", h))
+	for i := 1; i <= 3; i++ {
+		l.Info("hi")
+		if n, ok := r.LookupInt64("seq"); !ok || n != int64(i) {
+			t.Fatalf("Expected seq=%d, got %v", i, r.Ctx)
+		}
+	}
+}
+
+func TestLogBuildInfo(t *testing.T) {
+	t.Parallel()
+
+	l, _, r := testLogger()
+	LogBuildInfo(l)
+
+	if r.Lvl != LvlInfo || r.Msg != "build info" {
+		t.Fatalf("Unexpected record %v %q", r.Lvl, r.Msg)
+	}
+	if v, ok := r.LookupString("go"); !ok || v != runtime.Version() {
+		t.Fatalf("Expected go=%s, got %q", runtime.Version(), v)
+	}
+}
+
+func TestTerminalTheme(t *testing.T) {
+	t.Parallel()
+
+	l, buf := testFormatter(TerminalFormatWith(TerminalOptions{Theme: DarkTheme, MsgWidth: -1}))
+	l.SetTimestamp(false)
+	l.Error("failed", "x", 1)
+	l.Info("done")
+
+	expected := "\x1b[38;5;196mEROR\x1b[0m \x1b[1mfailed\x1b[0m \x1b[38;5;196mx\x1b[0m=1\n" +
+		"\x1b[38;5;42mINFO\x1b[0m done \n"
+	if got := buf.String(); got != expected {
+		t.Fatalf("Got %q, expected %q", got, expected)
+	}
+
+	// every level is styled by the built-in themes
+	for name, theme := range map[string]Theme{"dark": DarkTheme, "light": LightTheme, "monochrome": MonochromeTheme} {
+		l, buf := testFormatter(TerminalFormatWith(TerminalOptions{Theme: theme}))
+		l.SetLevel(LvlDebug)
+		l.SetTimestamp(false)
+		for lvl, log := range []func(string, ...interface{}){l.Crit, l.Error, l.Warn, l.Info, l.Debug} {
+			buf.Reset()
+			log("msg")
+			if !strings.HasPrefix(buf.String(), "\x1b[") {
+				t.Fatalf("Expected styled level %s in %s theme, got %q", Lvl(lvl), name, buf.String())
+			}
+		}
+	}
+}
+
+func TestColorMode(t *testing.T) {
+	// not parallel: changes the environment
+	f, err := ioutil.TempFile("", "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	vars := []string{"NO_COLOR", "FORCE_COLOR", "CLICOLOR_FORCE", "CLICOLOR"}
+	for _, k := range vars {
+		if v, ok := os.LookupEnv(k); ok {
+			defer os.Setenv(k, v)
+		} else {
+			defer os.Unsetenv(k)
+		}
+	}
+	for _, tt := range []struct {
+		env   map[string]string
+		color bool
+	}{
+		{nil, false},
+		{map[string]string{"FORCE_COLOR": "1"}, true},
+		{map[string]string{"FORCE_COLOR": "0"}, false},
+		{map[string]string{"CLICOLOR_FORCE": "1"}, true},
+		{map[string]string{"FORCE_COLOR": "1", "NO_COLOR": "1"}, false},
+	} {
+		for _, k := range vars {
+			os.Unsetenv(k)
+		}
+		for k, v := range tt.env {
+			os.Setenv(k, v)
+		}
+		if terminal, color := colorMode(f); terminal || color != tt.color {
+			t.Fatalf("Env %v: expected color %v, got terminal %v color %v", tt.env, tt.color, terminal, color)
+		}
+	}
+}
+
+func TestNoTimestamp(t *testing.T) {
+	t.Parallel()
+
+	l, buf := testFormatter(LogfmtFormat())
+	l.SetTimestamp(false)
+	l.Info("no time", "x", 1)
+
+	expected := "lvl=info msg=\"no time\" x=1\n"
+	if got := buf.String(); got != expected {
+		t.Fatalf("Got %s, expected %s", got, expected)
+	}
+
+	h, r := testHandler()
+	child := l.New()
+	child.SetHandler(h)
+	child.Info("child")
+	if !r.Time.IsZero() {
+		t.Fatalf("child logger should inherit disabled timestamps, got %v", r.Time)
+	}
+}
+
+func TestSampler(t *testing.T) {
+	t.Parallel()
+
+	var n int
+	l := New()
+	l.SetHandler(FuncHandler(func(r *Record) error {
+		n++
+		return nil
+	}))
+	l.SetSampler(CountSampler(time.Hour, 3, 5))
+
+	for i := 0; i < 23; i++ {
+		l.Info("sampled")
+	}
+	// first 3, then records 8, 13, 18 and 23
+	if n != 7 {
+		t.Fatalf("Expected 7 sampled records, got %d", n)
+	}
+
+	n = 0
+	for i := 0; i < 10; i++ {
+		l.Error("not sampled")
+	}
+	if n != 10 {
+		t.Fatalf("Expected error records to bypass sampling, got %d of 10", n)
+	}
+
+	n = 0
+	l.SetSampler(nil)
+	for i := 0; i < 10; i++ {
+		l.Info("sampled")
+	}
+	if n != 10 {
+		t.Fatalf("Expected nil sampler to disable sampling, got %d of 10", n)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	t.Parallel()
+
+	l, _, r := testLogger()
+	var fired []string
+	l.AddHook(HookFunc(func(r *Record) bool {
+		fired = append(fired, r.Msg)
+		for i := 0; i < len(r.Ctx); i += 2 {
+			if r.Ctx[i] == "password" {
+				r.Ctx[i+1] = "REDACTED"
+			}
+		}
+		return true
+	}))
+	l.AddHook(HookFunc(func(r *Record) bool {
+		return r.Msg != "vetoed"
+	}))
+
+	l.Info("login", "password", "hunter2")
+	if r.Ctx[1] != "REDACTED" {
+		t.Fatalf("Expected hook to redact value, got %v", r.Ctx[1])
+	}
+
+	l.Info("vetoed")
+	if r.Msg != "login" {
+		t.Fatalf("Expected vetoed record to be dropped, got %s", r.Msg)
+	}
+
+	child := l.New()
+	child.Info("child")
+	if len(fired) != 3 || fired[2] != "child" {
+		t.Fatalf("Expected hooks to fire for parent and child records, got %v", fired)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	t.Parallel()
+
+	parent, _, _ := testLogger()
+	child := parent.New("child", true)
+
+	var errs []Record
+	cancel := parent.Subscribe(func(r *Record) bool {
+		return r.Lvl <= LvlError
+	}, func(r Record) {
+		errs = append(errs, r)
+	})
+
+	child.Info("ignored")
+	child.Error("failed", "code", 7)
+	parent.Crit("down")
+	if len(errs) != 2 || errs[0].Msg != "failed" || errs[1].Msg != "down" {
+		t.Fatalf("Wrong subscribed records: %v", errs)
+	}
+	if len(errs[0].Ctx) != 4 || errs[0].Ctx[3] != 7 {
+		t.Fatalf("Expected record context in subscription, got %v", errs[0].Ctx)
+	}
+
+	cancel()
+	parent.Error("after cancel")
+	if len(errs) != 2 {
+		t.Fatalf("Expected no records after cancel, got %d", len(errs))
+	}
+}
+
 func TestMultiHandler(t *testing.T) {
 	t.Parallel()
 
 	h1, r1 := testHandler()
 	h2, r2 := testHandler()
 	l := New()
+	l.SetLevel(LvlDebug)
 	l.SetHandler(MultiHandler(h1, h2))
 	l.Debug("clone")
 
@@ -159,6 +1064,23 @@ func TestMultiHandler(t *testing.T) {
 
 }
 
+func TestMultiHandlerErrors(t *testing.T) {
+	t.Parallel()
+
+	h, r := testHandler()
+	fail := FuncHandler(func(r *Record) error {
+		return errors.New("fail")
+	})
+
+	err := MultiHandler(fail, LvlFilterHandler(LvlError, h)).Log(&Record{Lvl: LvlError, Msg: "written"})
+	if err == nil || err.Error() != "fail" {
+		t.Fatalf("Expected error from failing handler, got %v", err)
+	}
+	if r.Msg != "written" {
+		t.Fatalf("Expected record to reach the handler after a failing one")
+	}
+}
+
 type waitHandler struct {
 	ch chan Record
 }
@@ -173,6 +1095,7 @@ func TestBufferedHandler(t *testing.T) {
 
 	ch := make(chan Record)
 	l := New()
+	l.SetLevel(LvlDebug)
 	l.SetHandler(BufferedHandler(0, &waitHandler{ch}))
 
 	l.Debug("buffer")
@@ -319,6 +1242,7 @@ func TestMatchFilterBuiltin(t *testing.T) {
 	t.Parallel()
 
 	l, h, r := testLogger()
+	l.SetLevel(LvlDebug)
 	l.SetHandler(MatchFilterHandler("lvl", LvlError, h))
 	l.Info("does not pass")
 
@@ -362,6 +1286,7 @@ func TestFailoverHandler(t *testing.T) {
 	h, r := testHandler()
 	w := &failingWriter{false}
 
+	l.SetLevel(LvlDebug)
 	l.SetHandler(FailoverHandler(
 		StreamHandler(w, JSONFormat()),
 		h))
@@ -388,6 +1313,325 @@ func TestFailoverHandler(t *testing.T) {
 	}
 }
 
+func TestPrintf(t *testing.T) {
+	t.Parallel()
+
+	l, _, r := testLogger()
+	l.Infof("user %s logged in %d times, 100%% ok", "bob", 3, "ip", "10.0.0.1")
+	if r.Msg != "user bob logged in 3 times, 100% ok" {
+		t.Fatalf("Wrong message: %q", r.Msg)
+	}
+	if fmt.Sprint(r.Ctx) != "[ip 10.0.0.1]" {
+		t.Fatalf("Expected leftover arguments as context, got %v", r.Ctx)
+	}
+
+	l.Errorf("width %*d", 5, 42)
+	if r.Msg != "width    42" || len(r.Ctx) != 0 {
+		t.Fatalf("Wrong record: %q %v", r.Msg, r.Ctx)
+	}
+
+	l.Warnf("%[2]s before %[1]s", "b", "a", "id", 7)
+	if r.Msg != "a before b" || fmt.Sprint(r.Ctx) != "[id 7]" {
+		t.Fatalf("Wrong record for indexed verbs: %q %v", r.Msg, r.Ctx)
+	}
+
+	r.Msg = ""
+	l.Debugf("filtered %s", "out")
+	if r.Msg != "" {
+		t.Fatalf("Expected debug record to be filtered, got %q", r.Msg)
+	}
+}
+
+func TestPanic(t *testing.T) {
+	t.Parallel()
+
+	panics := func(fn func()) (v interface{}) {
+		defer func() { v = recover() }()
+		fn()
+		return nil
+	}
+
+	l, _, r := testLogger()
+	if v := panics(func() { l.Panic("boom", "x", 1) }); v != "boom" {
+		t.Fatalf("Expected panic with message, got %v", v)
+	}
+	if r.Msg != "boom" || r.Lvl != LvlCrit || fmt.Sprint(r.Ctx) != "[x 1]" {
+		t.Fatalf("Wrong record: %+v", r)
+	}
+
+	r.Msg = ""
+	if v := panics(func() { l.DPanic("odd") }); v != nil {
+		t.Fatalf("Expected no panic outside development mode, got %v", v)
+	}
+	if r.Msg != "odd" || r.Lvl != LvlCrit {
+		t.Fatalf("Wrong record: %+v", r)
+	}
+
+	l.SetDevelopment(true)
+	if v := panics(func() { l.New("child", true).DPanic("odd") }); v != "odd" {
+		t.Fatalf("Expected panic in development mode, got %v", v)
+	}
+}
+
+func TestCritExit(t *testing.T) {
+	// not parallel: changes the root logger and the exit function
+	h, r := testHandler()
+	old := Root().GetHandler()
+	Root().SetHandler(h)
+	defer Root().SetHandler(old)
+
+	var calls []string
+	OnExit(func() { calls = append(calls, "hook:"+r.Msg) })
+	SetExitFunc(func(code int) { calls = append(calls, fmt.Sprint("exit:", code)) })
+	defer func() {
+		SetExitFunc(nil)
+		exitHooks = nil
+	}()
+
+	Crit("fatal")
+	Critf("fatal %d", 2)
+	if fmt.Sprint(calls) != "[hook:fatal exit:1 hook:fatal 2 exit:1]" {
+		t.Fatalf("Wrong exit sequence: %v", calls)
+	}
+}
+
+func TestFlushOnPanic(t *testing.T) {
+	// not parallel: changes the root logger and the exit hooks
+	h, r := testHandler()
+	old := Root().GetHandler()
+	Root().SetHandler(h)
+	defer Root().SetHandler(old)
+
+	flushed := false
+	OnExit(func() { flushed = true })
+	defer func() { exitHooks = nil }()
+
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Fatalf("Expected panic to continue, got %v", p)
+			}
+		}()
+		defer FlushOnPanic()
+		panic("boom")
+	}()
+	if !flushed || r.Msg != "panic" || r.Lvl != LvlCrit {
+		t.Fatalf("Expected panic record and flush, got %+v, flushed %v", r, flushed)
+	}
+	if v, _ := r.LookupString("stack"); !strings.Contains(v, "TestFlushOnPanic") {
+		t.Fatalf("Expected stack in record, got %q", v)
+	}
+}
+
+func TestFlushOnSignal(t *testing.T) {
+	// not parallel: changes the root logger and the exit function
+	h, r := testHandler()
+	old := Root().GetHandler()
+	Root().SetHandler(h)
+	defer Root().SetHandler(old)
+
+	codes := make(chan int, 1)
+	SetExitFunc(func(code int) { codes <- code })
+	defer SetExitFunc(nil)
+
+	stop := FlushOnSignal(os.Interrupt)
+	defer stop()
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Signal(os.Interrupt); err != nil {
+		t.Skip("cannot signal own process:", err)
+	}
+
+	select {
+	case code := <-codes:
+		if code != 130 || r.Msg != "terminated by signal" {
+			t.Fatalf("Wrong exit: code %d, record %+v", code, r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for exit")
+	}
+}
+
+func TestSetKeyPrefix(t *testing.T) {
+	t.Parallel()
+
+	l, h, r := testLogger()
+	l = l.New("svc", "api")
+	l.SetHandler(h)
+	l.SetKeyPrefix("app.")
+
+	l.Info("test", "user", "bob")
+	if fmt.Sprint(r.Ctx) != fmt.Sprint([]interface{}{"app.svc", "api", "app.user", "bob"}) {
+		t.Fatalf("Expected prefixed keys, got %v", r.Ctx)
+	}
+
+	l.New("req", 1).Info("child")
+	if fmt.Sprint(r.Ctx) != fmt.Sprint([]interface{}{"app.svc", "api", "app.req", 1}) {
+		t.Fatalf("Expected child to inherit prefix, got %v", r.Ctx)
+	}
+
+	l.SetKeyPrefix("")
+	l.Info("test", "user", "bob")
+	if fmt.Sprint(r.Ctx) != fmt.Sprint([]interface{}{"svc", "api", "user", "bob"}) {
+		t.Fatalf("Expected unprefixed keys, got %v", r.Ctx)
+	}
+}
+
+func TestCompose(t *testing.T) {
+	t.Parallel()
+
+	var order []string
+	mark := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return FuncHandler(func(r *Record) error {
+				order = append(order, name)
+				return next.Log(r)
+			})
+		}
+	}
+
+	h, r := testHandler()
+	l := New()
+	l.SetHandler(Compose(
+		mark("filter"),
+		func(next Handler) Handler { return LvlFilterHandler(LvlWarn, next) },
+		mark("transport"),
+	)(h))
+
+	l.Info("dropped")
+	l.Warn("kept")
+	if r.Msg != "kept" || fmt.Sprint(order) != "[filter filter transport]" {
+		t.Fatalf("Wrong order of middlewares: %v, record %q", order, r.Msg)
+	}
+
+	l.SetHandler(Compose()(h))
+	l.Info("direct")
+	if r.Msg != "direct" || len(order) != 3 {
+		t.Fatalf("Expected empty composition to pass records through, got %q", r.Msg)
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	t.Parallel()
+
+	l, h, _ := testLogger()
+	var msgs []string
+	l.SetHandler(MultiHandler(h, FuncHandler(func(r *Record) error {
+		msgs = append(msgs, r.Lvl.String()+":"+r.Msg)
+		return nil
+	})))
+
+	w := NewLineWriter(l, LvlWarn)
+	fmt.Fprint(w, "first\r\nsec")
+	fmt.Fprint(w, "ond\n\n  \nthi")
+	fmt.Fprint(w, "rd")
+	if fmt.Sprint(msgs) != "[warn:first warn:second]" {
+		t.Fatalf("Wrong records before Close: %v", msgs)
+	}
+	w.Close()
+	if fmt.Sprint(msgs) != "[warn:first warn:second warn:third]" {
+		t.Fatalf("Wrong records after Close: %v", msgs)
+	}
+}
+
+func TestCallerString(t *testing.T) {
+	// not parallel: AllocsPerRun
+	c := stack.Caller(0)
+	if s := callerString(c); s != fmt.Sprint(c) {
+		t.Fatalf("Wrong caller: %q, expected %q", s, fmt.Sprint(c))
+	}
+	if n := testing.AllocsPerRun(100, func() { callerString(c) }); n != 0 {
+		t.Fatalf("Expected cached caller without allocations, got %v", n)
+	}
+}
+
+func TestProvenanceHandler(t *testing.T) {
+	t.Parallel()
+
+	l, h, r := testLogger()
+	l.SetHandler(ProvenanceHandler(RenameHandler(map[string]string{"user": "user.name"}, h)))
+
+	l.Info("test", "user", "bob")
+	if fmt.Sprint(r.Ctx) != "[user.name bob provenance rename]" {
+		t.Fatalf("Expected rename in provenance, got %v", r.Ctx)
+	}
+
+	l.Info("test", "id", 1)
+	if fmt.Sprint(r.Ctx) != "[id 1 provenance ]" {
+		t.Fatalf("Expected empty provenance, got %v", r.Ctx)
+	}
+
+	// without ProvenanceHandler nothing is added
+	l.SetHandler(RenameHandler(map[string]string{"user": "user.name"}, h))
+	l.Info("test", "user", "bob")
+	if fmt.Sprint(r.Ctx) != "[user.name bob]" {
+		t.Fatalf("Expected no provenance, got %v", r.Ctx)
+	}
+}
+
+func TestRenameHandler(t *testing.T) {
+	t.Parallel()
+
+	l, h, r := testLogger()
+	orig := new(Record)
+	l.SetHandler(MultiHandler(
+		RenameHandler(map[string]string{"msg": "message", "user": "user.name"}, h),
+		FuncHandler(func(r *Record) error { *orig = *r; return nil })))
+
+	l.Info("test", "user", "bob", "id", 1)
+	if r.KeyNames.Msg != "message" || r.KeyNames.Lvl != "lvl" {
+		t.Fatalf("Wrong record keys: %+v", r.KeyNames)
+	}
+	if fmt.Sprint(r.Ctx) != fmt.Sprint([]interface{}{"user.name", "bob", "id", 1}) {
+		t.Fatalf("Wrong context keys: %v", r.Ctx)
+	}
+	if orig.KeyNames.Msg != "msg" || orig.Ctx[0] != "user" {
+		t.Fatalf("Expected other handlers to see the original keys, got %+v", orig)
+	}
+}
+
+func TestDevProdHandler(t *testing.T) {
+	t.Parallel()
+
+	l, h, r := testLogger()
+	l.SetLevel(LvlDebug)
+	l.SetHandler(DevProdHandler(LvlDebug, LvlWarn, h))
+
+	l.Warn("to prod")
+	l.Info("dev only")
+	if r.Msg != "to prod" {
+		t.Fatalf("Expected prod to get only warnings and up, got %q", r.Msg)
+	}
+}
+
+func TestSetHandlerForLevel(t *testing.T) {
+	t.Parallel()
+
+	l, _, r := testLogger()
+	eh, er := testHandler()
+	l.SetHandlerForLevel(LvlError, eh)
+
+	l.Error("to error handler")
+	l.Info("to default handler")
+	if er.Msg != "to error handler" || r.Msg != "to default handler" {
+		t.Fatalf("Wrong routing, error handler got %q, default got %q", er.Msg, r.Msg)
+	}
+
+	child := l.New()
+	child.Error("child error")
+	if er.Msg != "child error" {
+		t.Fatalf("Expected child to inherit level handler, got %q", er.Msg)
+	}
+
+	l.SetHandlerForLevel(LvlError, nil)
+	l.Error("back to default")
+	if r.Msg != "back to default" {
+		t.Fatalf("Expected nil handler to restore default routing, got %q", r.Msg)
+	}
+}
+
 func TestIndependentSetHandler(t *testing.T) {
 	t.Parallel()
 
@@ -443,6 +1687,39 @@ func TestCallerFileHandler(t *testing.T) {
 	}
 }
 
+func TestEnableCaller(t *testing.T) {
+	t.Parallel()
+
+	l, buf := testFormatter(LogfmtFormat())
+	l.SetTimestamp(false)
+	l.EnableCaller(0)
+	l.Info("baz")
+	_, _, line, _ := runtime.Caller(0)
+
+	expected := fmt.Sprintf("lvl=info msg=baz caller=log_test.go:%d\n", line-1)
+	if buf.String() != expected {
+		t.Fatalf("Got %s, expected %s", buf.String(), expected)
+	}
+
+	buf.Reset()
+	l.EnableCaller(1)
+	helper := func() { l.Info("helped") }
+	helper()
+	_, _, line, _ = runtime.Caller(0)
+
+	expected = fmt.Sprintf("lvl=info msg=helped caller=log_test.go:%d\n", line-1)
+	if buf.String() != expected {
+		t.Fatalf("Got %s, expected %s", buf.String(), expected)
+	}
+
+	buf.Reset()
+	l.EnableCaller(-1)
+	l.Info("baz")
+	if expected := "lvl=info msg=baz\n"; buf.String() != expected {
+		t.Fatalf("Got %s, expected %s", buf.String(), expected)
+	}
+}
+
 func TestCallerFuncHandler(t *testing.T) {
 	t.Parallel()
 
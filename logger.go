@@ -2,6 +2,11 @@ package log
 
 import (
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-stack/stack"
@@ -10,6 +15,7 @@ import (
 const timeKey = "t"
 const lvlKey = "lvl"
 const msgKey = "msg"
+const callerKey = "caller"
 const errorKey = "LOG_ERROR"
 
 // Lvl is a type for predefined log levels.
@@ -71,14 +77,91 @@ type Record struct {
 	KeyNames RecordKeyNames
 }
 
+// Lookup returns the value of the first context entry with the given key.
+func (r *Record) Lookup(key string) (interface{}, bool) {
+	for i := 0; i+1 < len(r.Ctx); i += 2 {
+		if k, ok := r.Ctx[i].(string); ok && k == key {
+			return r.Ctx[i+1], true
+		}
+	}
+	return nil, false
+}
+
+// LookupString returns the context value for key if it is a string.
+func (r *Record) LookupString(key string) (string, bool) {
+	v, _ := r.Lookup(key)
+	s, ok := v.(string)
+	return s, ok
+}
+
+// LookupInt64 returns the context value for key if it is an integer of
+// any size. Unsigned values that overflow an int64 are not reported.
+func (r *Record) LookupInt64(key string) (int64, bool) {
+	v, _ := r.Lookup(key)
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint:
+		return int64(n), uint64(n) <= math.MaxInt64
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	case uint64:
+		return int64(n), n <= math.MaxInt64
+	}
+	return 0, false
+}
+
+// LookupFloat64 returns the context value for key if it is a number.
+func (r *Record) LookupFloat64(key string) (float64, bool) {
+	v, _ := r.Lookup(key)
+	switch n := v.(type) {
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	if i, ok := r.LookupInt64(key); ok {
+		return float64(i), true
+	}
+	return 0, false
+}
+
+// LookupBool returns the context value for key if it is a bool.
+func (r *Record) LookupBool(key string) (bool, bool) {
+	v, _ := r.Lookup(key)
+	b, ok := v.(bool)
+	return b, ok
+}
+
 // RecordKeyNames are the predefined names of the log props used by the Logger interface.
+//
+// Call is only set for records of loggers with caller capture enabled, see
+// Logger.EnableCaller. Formats render the record's call site under that key
+// when it is not empty.
 type RecordKeyNames struct {
 	Time string
 	Msg  string
 	Lvl  string
+	Call string
 }
 
 // A Logger writes key/value pairs to a Handler
+//
+// Methods are added to Logger as the package grows, which breaks types
+// implementing it elsewhere, such as mocks. Embed a Logger returned by New
+// in such types and override only the methods of interest.
 type Logger interface {
 	// New returns a new Logger that has this logger's context plus the given context
 	New(ctx ...interface{}) Logger
@@ -89,33 +172,145 @@ type Logger interface {
 	// SetHandler updates the logger to write records to the specified handler.
 	SetHandler(h Handler)
 
-	// SetLevel update level of logger
+	// SetLevel update level of logger. It is safe to call while other
+	// goroutines are logging, e.g. to raise verbosity at runtime.
 	SetLevel(lvl Lvl)
 
+	// GetLevel returns the level of the logger
+	GetLevel() Lvl
+
+	// SetHandlerForLevel routes records of the given level to h instead of
+	// the logger's handler, e.g. to write errors to a durable synchronous
+	// handler while other records are buffered. A nil h restores the
+	// default routing for that level.
+	SetHandlerForLevel(lvl Lvl, h Handler)
+
+	// SetTimestamp enables or disables stamping records with the current time.
+	// Records written with timestamps disabled have a zero Time which the
+	// built-in formats omit from their output.
+	SetTimestamp(enabled bool)
+
+	// SetDevelopment enables or disables development mode, in which DPanic
+	// panics after logging. Loggers created with New inherit the mode.
+	SetDevelopment(enabled bool)
+
+	// SetKeyPrefix prepends prefix, e.g. "app.", to the keys of all
+	// context pairs written by the logger and the loggers later created
+	// from it, to namespace them in shared indexes. An empty prefix
+	// disables prefixing.
+	SetKeyPrefix(prefix string)
+
+	// SetSampler installs a Sampler consulted for every record that passes
+	// the level check. A nil Sampler disables sampling.
+	SetSampler(s Sampler)
+
+	// AddHook registers a Hook run on each record before it is written.
+	// Hooks run in the order they were added.
+	AddHook(h Hook)
+
+	// EnableCaller makes the logger record the file and line of the code
+	// calling it, which formats then include in their output. skip is the
+	// number of additional stack frames to ascend, e.g. 1 when the logger
+	// is called through a helper function. A negative skip disables caller
+	// capture, which is the default.
+	EnableCaller(skip int)
+
+	// Subscribe calls fn with a copy of every record written by the logger
+	// or its descendants for which filter returns true. A nil filter
+	// matches all records. fn runs synchronously on the logging goroutine
+	// after hooks, so it must be quick and must not log to the same logger.
+	// Calling the returned function cancels the subscription.
+	Subscribe(filter func(r *Record) bool, fn func(r Record)) (cancel func())
+
 	// Log a message at the given level with context key/value pairs
 	Debug(msg string, ctx ...interface{})
 	Info(msg string, ctx ...interface{})
 	Warn(msg string, ctx ...interface{})
 	Error(msg string, ctx ...interface{})
 	Crit(msg string, ctx ...interface{})
+
+	// Log a message formatted with fmt.Sprintf at the given level. Any
+	// arguments left over after those consumed by format are key/value
+	// context pairs, so
+	//
+	//     l.Infof("user %s logged in", name, "ip", addr)
+	//
+	// logs the message "user bob logged in" with the context ip=addr.
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	Critf(format string, args ...interface{})
+
+	// Panic logs a message at LvlCrit and then panics with the message,
+	// so deferred calls run and the panic can be recovered. DPanic logs
+	// the same way but only panics in development mode, for conditions
+	// that should stop a developer but not a production service. Both
+	// panic even if the record is filtered out by the logger's level.
+	Panic(msg string, ctx ...interface{})
+	DPanic(msg string, ctx ...interface{})
 }
 
 type logger struct {
-	ctx []interface{}
-	lvl Lvl
-	h   *swapHandler
+	ctx    []interface{}
+	h      *swapHandler
+	mu     sync.Mutex   // serializes configuration updates
+	cfg    atomic.Value // *loggerConfig
+	subs   hooks
+	parent *logger
+}
+
+// loggerConfig is the runtime configuration of a logger. A stored
+// configuration is never modified: setters store an updated copy, so the
+// logging path reads a consistent configuration with a single atomic load
+// and no locking.
+type loggerConfig struct {
+	lvl     Lvl
+	notime  bool
+	sampler Sampler
+	hooks   []Hook
+	lvlh    [LvlDebug + 1]Handler
+	prefix  string
+	pctx    []interface{} // ctx with prefixed keys
+	caller  bool
+	skip    int
+	dev     bool
+}
+
+func newLogger(ctx []interface{}, parent *logger, cfg *loggerConfig) *logger {
+	l := &logger{ctx: ctx, h: new(swapHandler), parent: parent}
+	l.cfg.Store(cfg)
+	return l
+}
+
+func (l *logger) config() *loggerConfig {
+	return l.cfg.Load().(*loggerConfig)
+}
+
+// update applies fn to a copy of the logger's configuration and stores
+// the copy.
+func (l *logger) update(fn func(c *loggerConfig)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c := *l.config()
+	fn(&c)
+	l.cfg.Store(&c)
 }
 
 func (l *logger) write(msg string, lvl Lvl, ctx []interface{}) {
-	if l.lvl < lvl {
+	c := l.config()
+	if c.lvl < lvl {
+		return
+	}
+
+	if c.sampler != nil && !c.sampler.Sample(lvl, msg) {
 		return
 	}
 
 	r := &Record{
-		Time: time.Now(),
-		Lvl:  lvl,
-		Msg:  msg,
-		Ctx:  newContext(l.ctx, ctx),
+		Lvl: lvl,
+		Msg: msg,
+		Ctx: l.context(c, ctx),
 		KeyNames: RecordKeyNames{
 			Time: timeKey,
 			Msg:  msgKey,
@@ -123,15 +318,58 @@ func (l *logger) write(msg string, lvl Lvl, ctx []interface{}) {
 		},
 	}
 
-	if l.lvl >= LvlDebug {
+	if !c.notime {
+		r.Time = time.Now()
+	}
+
+	if c.caller {
+		r.Call = stack.Caller(2 + c.skip)
+		r.KeyNames.Call = callerKey
+	} else if c.lvl >= LvlDebug {
 		r.Call = stack.Caller(2)
 	}
 
-	l.h.Log(r)
+	for _, h := range c.hooks {
+		if !h.Fire(r) {
+			return
+		}
+	}
+
+	for p := l; p != nil; p = p.parent {
+		p.subs.fire(r)
+	}
+
+	h := Handler(l.h)
+	if lvl >= 0 && int(lvl) < len(c.lvlh) && c.lvlh[lvl] != nil {
+		h = c.lvlh[lvl]
+	}
+	h.Log(r)
+}
+
+// context returns the context of a record logged with ctx, with the key
+// prefix applied. The logger's own context is prefixed in advance.
+func (l *logger) context(c *loggerConfig, ctx []interface{}) []interface{} {
+	if c.prefix == "" {
+		return newContext(l.ctx, ctx)
+	}
+	nc := newContext(c.pctx, ctx)
+	prefixKeys(c.prefix, nc[len(c.pctx):])
+	return nc
+}
+
+func prefixKeys(prefix string, ctx []interface{}) {
+	for i := 0; i < len(ctx); i += 2 {
+		if k, ok := ctx[i].(string); ok {
+			ctx[i] = prefix + k
+		}
+	}
 }
 
 func (l *logger) New(ctx ...interface{}) Logger {
-	child := &logger{newContext(l.ctx, ctx), LvlInfo, new(swapHandler)}
+	c := *l.config()
+	c.lvl = LvlInfo
+	child := newLogger(newContext(l.ctx, ctx), l, &c)
+	child.SetKeyPrefix(c.prefix)
 	child.SetHandler(l.h)
 	return child
 }
@@ -145,7 +383,60 @@ func newContext(prefix []interface{}, suffix []interface{}) []interface{} {
 }
 
 func (l *logger) SetLevel(lvl Lvl) {
-	l.lvl = lvl
+	l.update(func(c *loggerConfig) { c.lvl = lvl })
+}
+
+func (l *logger) GetLevel() Lvl {
+	return l.config().lvl
+}
+
+func (l *logger) SetHandlerForLevel(lvl Lvl, h Handler) {
+	if lvl < 0 || int(lvl) > int(LvlDebug) {
+		return
+	}
+	l.update(func(c *loggerConfig) { c.lvlh[lvl] = h })
+}
+
+func (l *logger) SetTimestamp(enabled bool) {
+	l.update(func(c *loggerConfig) { c.notime = !enabled })
+}
+
+func (l *logger) SetDevelopment(enabled bool) {
+	l.update(func(c *loggerConfig) { c.dev = enabled })
+}
+
+func (l *logger) SetKeyPrefix(prefix string) {
+	l.update(func(c *loggerConfig) {
+		c.prefix = prefix
+		c.pctx = nil
+		if prefix != "" {
+			c.pctx = append([]interface{}(nil), l.ctx...)
+			prefixKeys(prefix, c.pctx)
+		}
+	})
+}
+
+func (l *logger) SetSampler(s Sampler) {
+	l.update(func(c *loggerConfig) { c.sampler = s })
+}
+
+func (l *logger) AddHook(h Hook) {
+	l.update(func(c *loggerConfig) {
+		c.hooks = append(c.hooks[:len(c.hooks):len(c.hooks)], h)
+	})
+}
+
+func (l *logger) EnableCaller(skip int) {
+	l.update(func(c *loggerConfig) {
+		c.caller = skip >= 0
+		c.skip = skip
+	})
+}
+
+func (l *logger) Subscribe(filter func(r *Record) bool, fn func(r Record)) (cancel func()) {
+	s := &subscription{filter, fn}
+	l.subs.add(s)
+	return func() { l.subs.remove(s) }
 }
 
 func (l *logger) Debug(msg string, ctx ...interface{}) {
@@ -168,6 +459,114 @@ func (l *logger) Crit(msg string, ctx ...interface{}) {
 	l.write(msg, LvlCrit, ctx)
 }
 
+func (l *logger) Panic(msg string, ctx ...interface{}) {
+	l.write(msg, LvlCrit, ctx)
+	panic(msg)
+}
+
+func (l *logger) DPanic(msg string, ctx ...interface{}) {
+	l.write(msg, LvlCrit, ctx)
+	if l.config().dev {
+		panic(msg)
+	}
+}
+
+func (l *logger) Debugf(format string, args ...interface{}) {
+	if l.enabled(LvlDebug) {
+		msg, ctx := sprintf(format, args)
+		l.write(msg, LvlDebug, ctx)
+	}
+}
+
+func (l *logger) Infof(format string, args ...interface{}) {
+	if l.enabled(LvlInfo) {
+		msg, ctx := sprintf(format, args)
+		l.write(msg, LvlInfo, ctx)
+	}
+}
+
+func (l *logger) Warnf(format string, args ...interface{}) {
+	if l.enabled(LvlWarn) {
+		msg, ctx := sprintf(format, args)
+		l.write(msg, LvlWarn, ctx)
+	}
+}
+
+func (l *logger) Errorf(format string, args ...interface{}) {
+	if l.enabled(LvlError) {
+		msg, ctx := sprintf(format, args)
+		l.write(msg, LvlError, ctx)
+	}
+}
+
+func (l *logger) Critf(format string, args ...interface{}) {
+	if l.enabled(LvlCrit) {
+		msg, ctx := sprintf(format, args)
+		l.write(msg, LvlCrit, ctx)
+	}
+}
+
+// enabled reports whether records of lvl pass the logger's level, so
+// formatted messages are only built when they will be written.
+func (l *logger) enabled(lvl Lvl) bool {
+	return l.config().lvl >= lvl
+}
+
+// sprintf formats the message of a Printf-style call and returns the
+// arguments format does not consume as context.
+func sprintf(format string, args []interface{}) (string, []interface{}) {
+	n := countOperands(format)
+	if n >= len(args) {
+		return fmt.Sprintf(format, args...), nil
+	}
+	return fmt.Sprintf(format, args[:n]...), args[n:]
+}
+
+// countOperands returns the number of arguments format consumes. An
+// explicit argument index such as %[2]d moves on from that argument, as
+// in fmt, so the highest argument used is counted. Formats with a
+// malformed index are assumed to consume all arguments.
+func countOperands(format string) int {
+	n, max := 0, 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		for i++; i < len(format); i++ {
+			c := format[i]
+			if c == '[' {
+				j := strings.IndexByte(format[i:], ']')
+				if j < 0 {
+					return math.MaxInt32
+				}
+				idx, err := strconv.Atoi(format[i+1 : i+j])
+				if err != nil || idx < 1 {
+					return math.MaxInt32
+				}
+				n = idx - 1
+				i += j
+				continue
+			}
+			if c == '*' {
+				if n++; n > max {
+					max = n
+				}
+				continue
+			}
+			if c == '%' && format[i-1] == '%' {
+				break
+			}
+			if strings.IndexByte("+-# 0123456789.", c) < 0 {
+				if n++; n > max {
+					max = n
+				}
+				break
+			}
+		}
+	}
+	return max
+}
+
 func (l *logger) GetHandler() Handler {
 	return l.h.Get()
 }
@@ -205,6 +604,16 @@ func normalize(ctx []interface{}) []interface{} {
 //
 // You may wrap any function which takes no arguments to Lazy. It may return any
 // number of values of any type.
+//
+// Lazy values are evaluated by the handler that formats the record, so
+// behind an asynchronous handler such as ext.AsyncHandler an expensive
+// value, e.g. the reverse DNS name of a client address, is computed on the
+// handler's goroutine without blocking the caller:
+//
+//     l.Info("connection", "host", log.Lazy{func() string { return lookupAddr(ip) }})
+//
+// The function then runs after the logging call returns, so it must only
+// use values that stay valid until then.
 type Lazy struct {
 	Fn interface{}
 }
@@ -214,6 +623,41 @@ type Lazy struct {
 // to the logging functions.
 type Ctx map[string]interface{}
 
+// Group is a context value made of key/value pairs that belong together,
+// such as the attributes of a request:
+//
+//     log.Info("served", "http", log.Group{"method", "GET", "status", 200})
+//
+// JSON formats write a group as a nested object, while LogfmtFormat and
+// TerminalFormat write each of its pairs with the group key as a dotted
+// prefix, as in http.method=GET http.status=200. Groups may be nested.
+type Group []interface{}
+
+// ObjectMarshaler is implemented by values that describe themselves as
+// key/value pairs. Formats write such a value like the Group returned by
+// MarshalLog, so a struct can choose the fields it logs without going
+// through fmt:
+//
+//     func (u User) MarshalLog() log.Group {
+//         return log.Group{"id", u.ID, "name", u.Name}
+//     }
+//
+// MarshalLog is called when the record is formatted.
+type ObjectMarshaler interface {
+	MarshalLog() Group
+}
+
+// asGroup returns the pairs of a Group or ObjectMarshaler value.
+func asGroup(v interface{}) (Group, bool) {
+	switch g := v.(type) {
+	case Group:
+		return g, true
+	case ObjectMarshaler:
+		return g.MarshalLog(), true
+	}
+	return nil, false
+}
+
 func (c Ctx) toArray() []interface{} {
 	arr := make([]interface{}, len(c)*2)
 
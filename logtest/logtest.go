@@ -0,0 +1,41 @@
+// Package logtest provides a logger that writes to the log of a test, so
+// records logged by the code under test show up in the go test output
+// next to the test that caused them, and only for failing tests unless
+// -v is given.
+package logtest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/semihalev/log"
+)
+
+// New returns a logger writing records of all levels to tb.Log in logfmt
+// format, without timestamps and with the file and line of the logging
+// call under the key "caller". The file and line go test puts in front of
+// each line point into the logger, not to the logging call, as the
+// testing package cannot skip the frames of the logger; read the caller
+// key instead:
+//
+//     func TestServer(t *testing.T) {
+//         srv := NewServer(logtest.New(t))
+//         ...
+//     }
+//
+// Loggers created from it with New start at LvlInfo, as all child loggers
+// do; call SetLevel on them to see their debug records. Like tb.Log
+// itself, the logger must not be used after the test has completed, so
+// stop goroutines of the code under test before returning.
+func New(tb testing.TB) log.Logger {
+	fmtr := log.LogfmtFormat()
+	l := log.New()
+	l.SetLevel(log.LvlDebug)
+	l.SetTimestamp(false)
+	l.EnableCaller(0)
+	l.SetHandler(log.LazyHandler(log.FuncHandler(func(r *log.Record) error {
+		tb.Log(strings.TrimSuffix(string(fmtr.Format(r)), "\n"))
+		return nil
+	})))
+	return l
+}
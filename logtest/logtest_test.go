@@ -0,0 +1,67 @@
+package logtest
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// recorder captures the lines logged through testing.TB.
+type recorder struct {
+	testing.TB
+	lines []string
+}
+
+func (r *recorder) Log(args ...interface{}) {
+	r.lines = append(r.lines, fmt.Sprint(args...))
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	tb := &recorder{TB: t}
+	l := New(tb)
+	l.Debug("created", "id", 1)
+	l.New("svc", "api").Warn("slow")
+
+	if len(tb.lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %q", tb.lines)
+	}
+	if !strings.HasPrefix(tb.lines[0], "lvl=dbug msg=created caller=logtest_test.go:") || !strings.HasSuffix(tb.lines[0], " id=1") {
+		t.Fatalf("Wrong line: %q", tb.lines[0])
+	}
+	if !strings.HasPrefix(tb.lines[1], "lvl=warn msg=slow caller=logtest_test.go:") || !strings.HasSuffix(tb.lines[1], " svc=api") {
+		t.Fatalf("Wrong line: %q", tb.lines[1])
+	}
+}
+
+func TestNewCaller(t *testing.T) {
+	if os.Getenv("LOGTEST_CHILD") == "1" {
+		New(t).Info("child", "line", callerLine())
+		return
+	}
+	t.Parallel()
+
+	// run the test again in a child process to read what a real
+	// *testing.T prints
+	cmd := exec.Command(os.Args[0], "-test.run=^TestNewCaller$", "-test.v")
+	cmd.Env = append(os.Environ(), "LOGTEST_CHILD=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	m := regexp.MustCompile(`msg=child caller=logtest_test.go:(\d+) line=(\d+)`).FindSubmatch(out)
+	if m == nil || string(m[1]) != string(m[2]) {
+		t.Fatalf("Expected caller to be the logging call, got:\n%s", out)
+	}
+}
+
+// callerLine returns the line it is called from.
+func callerLine() int {
+	_, _, line, _ := runtime.Caller(1)
+	return line
+}
@@ -0,0 +1,342 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Keys recognized by ParseJSON for the record time, level and message.
+// They cover the output of JSONFormat as well as the default encoders of
+// zap and zerolog.
+var (
+	jsonTimeKeys = []string{timeKey, "ts", "time", "timestamp"}
+	jsonLvlKeys  = []string{lvlKey, "level", "severity"}
+	jsonMsgKeys  = []string{msgKey, "message"}
+)
+
+// ParseJSON parses a single JSON log line, as written by JSONFormat, zap
+// or zerolog, into a Record. The well-known time, level and message keys
+// of those libraries are mapped onto the record; every other key is added
+// to the context in the order it appears in the line.
+//
+// Values are typed on a best-effort basis: integral numbers become int64,
+// other numbers float64, and objects and arrays are kept as decoded by
+// encoding/json. Unknown level names are treated as LvlInfo.
+func ParseJSON(line []byte) (*Record, error) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+
+	if tok, err := dec.Token(); err != nil {
+		return nil, err
+	} else if tok != json.Delim('{') {
+		return nil, errors.New("log: JSON record is not an object")
+	}
+
+	r := &Record{
+		Lvl: LvlInfo,
+		Ctx: []interface{}{},
+		KeyNames: RecordKeyNames{
+			Time: timeKey,
+			Msg:  msgKey,
+			Lvl:  lvlKey,
+		},
+	}
+	var hasTime, hasLvl, hasMsg bool
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := tok.(string)
+
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case !hasTime && inKeys(key, jsonTimeKeys):
+			if t, ok := parseJSONTime(v); ok {
+				r.Time, hasTime = t, true
+				continue
+			}
+		case !hasLvl && inKeys(key, jsonLvlKeys):
+			if s, ok := v.(string); ok {
+				r.Lvl, hasLvl = parseForeignLvl(s), true
+				continue
+			}
+		case !hasMsg && inKeys(key, jsonMsgKeys):
+			if s, ok := v.(string); ok {
+				r.Msg, hasMsg = s, true
+				continue
+			}
+		}
+
+		r.Ctx = append(r.Ctx, key, jsonValue(v))
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func inKeys(key string, keys []string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForeignLvl maps level names of this package, zap and zerolog onto
+// a Lvl.
+func parseForeignLvl(s string) Lvl {
+	s = strings.ToLower(s)
+	if lvl, err := LvlFromString(s); err == nil {
+		return lvl
+	}
+
+	switch s {
+	case "trace":
+		return LvlDebug
+	case "warning":
+		return LvlWarn
+	case "critical", "dpanic", "panic", "fatal":
+		return LvlCrit
+	default:
+		return LvlInfo
+	}
+}
+
+var jsonTimeLayouts = []string{
+	time.RFC3339Nano,
+	timeFormat,
+	"2006-01-02T15:04:05.000Z0700",
+}
+
+func parseJSONTime(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case string:
+		for _, layout := range jsonTimeLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, true
+			}
+		}
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		return unixTime(f), true
+	}
+	return time.Time{}, false
+}
+
+// unixTime converts a Unix timestamp in seconds, milliseconds, microseconds
+// or nanoseconds, guessed from its magnitude, to a time.Time.
+func unixTime(f float64) time.Time {
+	switch abs := math.Abs(f); {
+	case abs < 1e11:
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9))
+	case abs < 1e14:
+		return time.Unix(0, int64(f*1e6))
+	case abs < 1e17:
+		return time.Unix(0, int64(f*1e3))
+	default:
+		return time.Unix(0, int64(f))
+	}
+}
+
+func jsonValue(v interface{}) interface{} {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	if f, err := n.Float64(); err == nil {
+		return f
+	}
+	return fmt.Sprint(n)
+}
+
+// ParseRFC5424 parses a syslog message in RFC 5424 format into a Record.
+// The severity of the priority value is mapped onto the record level and
+// the message timestamp onto its time. The facility and the non-nil header
+// fields are added to the context under the keys "facility", "host",
+// "app", "procid" and "msgid", followed by every structured data parameter
+// keyed as "<SD-ID>.<PARAM-NAME>".
+func ParseRFC5424(msg []byte) (*Record, error) {
+	p := &syslogParser{buf: msg}
+
+	pri, err := p.pri()
+	if err != nil {
+		return nil, err
+	}
+	if !p.consume('1') || !p.consume(' ') {
+		return nil, errors.New("log: unsupported syslog version")
+	}
+
+	r := &Record{
+		Lvl: syslogLvl(pri & 7),
+		Ctx: []interface{}{"facility", pri >> 3},
+		KeyNames: RecordKeyNames{
+			Time: timeKey,
+			Msg:  msgKey,
+			Lvl:  lvlKey,
+		},
+	}
+
+	if ts := p.field(); ts != "-" {
+		if r.Time, err = time.Parse(time.RFC3339Nano, ts); err != nil {
+			return nil, fmt.Errorf("log: bad syslog timestamp: %v", err)
+		}
+	}
+	for _, key := range []string{"host", "app", "procid", "msgid"} {
+		if v := p.field(); v != "-" {
+			r.Ctx = append(r.Ctx, key, v)
+		}
+	}
+
+	if r.Ctx, err = p.structuredData(r.Ctx); err != nil {
+		return nil, err
+	}
+
+	p.consume(' ')
+	m := bytes.TrimPrefix(p.buf[p.pos:], []byte("\xef\xbb\xbf"))
+	r.Msg = strings.TrimRight(string(m), "\r\n")
+	return r, nil
+}
+
+// syslogLvl maps a syslog severity onto a Lvl.
+func syslogLvl(severity int) Lvl {
+	switch {
+	case severity <= 2: // emergency, alert, critical
+		return LvlCrit
+	case severity == 3:
+		return LvlError
+	case severity == 4:
+		return LvlWarn
+	case severity == 7:
+		return LvlDebug
+	default: // notice, informational
+		return LvlInfo
+	}
+}
+
+type syslogParser struct {
+	buf []byte
+	pos int
+}
+
+func (p *syslogParser) consume(c byte) bool {
+	if p.pos < len(p.buf) && p.buf[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *syslogParser) pri() (int, error) {
+	if !p.consume('<') {
+		return 0, errors.New("log: missing syslog priority")
+	}
+	pri, digits := 0, 0
+	for p.pos < len(p.buf) && p.buf[p.pos] >= '0' && p.buf[p.pos] <= '9' && digits < 3 {
+		pri = pri*10 + int(p.buf[p.pos]-'0')
+		p.pos++
+		digits++
+	}
+	if digits == 0 || pri > 191 || !p.consume('>') {
+		return 0, errors.New("log: bad syslog priority")
+	}
+	return pri, nil
+}
+
+// field returns the next space terminated header field.
+func (p *syslogParser) field() string {
+	start := p.pos
+	for p.pos < len(p.buf) && p.buf[p.pos] != ' ' {
+		p.pos++
+	}
+	f := string(p.buf[start:p.pos])
+	p.consume(' ')
+	return f
+}
+
+// structuredData appends the parameters of all SD-ELEMENTs to ctx.
+func (p *syslogParser) structuredData(ctx []interface{}) ([]interface{}, error) {
+	if p.consume('-') {
+		return ctx, nil
+	}
+
+	bad := errors.New("log: bad syslog structured data")
+	for p.consume('[') {
+		id := p.name()
+		if id == "" {
+			return nil, bad
+		}
+		for p.consume(' ') {
+			name := p.name()
+			if name == "" || !p.consume('=') || !p.consume('"') {
+				return nil, bad
+			}
+			value, ok := p.paramValue()
+			if !ok {
+				return nil, bad
+			}
+			ctx = append(ctx, id+"."+name, value)
+		}
+		if !p.consume(']') {
+			return nil, bad
+		}
+	}
+	return ctx, nil
+}
+
+// name reads an SD-NAME.
+func (p *syslogParser) name() string {
+	start := p.pos
+	for p.pos < len(p.buf) {
+		c := p.buf[p.pos]
+		if c <= ' ' || c > '~' || c == '=' || c == ']' || c == '"' {
+			break
+		}
+		p.pos++
+	}
+	return string(p.buf[start:p.pos])
+}
+
+// paramValue reads a PARAM-VALUE after its opening quote, unescaping
+// '"', '\' and ']'.
+func (p *syslogParser) paramValue() (string, bool) {
+	var b strings.Builder
+	for p.pos < len(p.buf) {
+		c := p.buf[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), true
+		case '\\':
+			if p.pos < len(p.buf) {
+				if n := p.buf[p.pos]; n == '"' || n == '\\' || n == ']' {
+					c = n
+					p.pos++
+				}
+			}
+		}
+		b.WriteByte(c)
+	}
+	return "", false
+}
@@ -2,11 +2,17 @@ package log
 
 import (
 	"os"
+	"sync"
 
-	"github.com/mattn/go-colorable"
 	isatty "github.com/mattn/go-isatty"
 )
 
+var (
+	exitMu    sync.Mutex
+	exitFunc  = os.Exit
+	exitHooks []func()
+)
+
 // Predefined handlers
 var (
 	root          *logger
@@ -15,18 +21,79 @@ var (
 )
 
 func init() {
-	if isatty.IsTerminal(os.Stdout.Fd()) {
-		StdoutHandler = StreamHandler(colorable.NewColorableStdout(), TerminalFormat())
+	if w, fmtr, ok := terminalOutput(os.Stdout); ok {
+		StdoutHandler = StreamHandler(w, fmtr)
 	}
 
-	if isatty.IsTerminal(os.Stderr.Fd()) {
-		StderrHandler = StreamHandler(colorable.NewColorableStderr(), TerminalFormat())
+	if w, fmtr, ok := terminalOutput(os.Stderr); ok {
+		StderrHandler = StreamHandler(w, fmtr)
 	}
 
-	root = &logger{[]interface{}{}, LvlInfo, new(swapHandler)}
+	root = newLogger([]interface{}{}, nil, &loggerConfig{lvl: LvlInfo})
 	root.SetHandler(StdoutHandler)
 }
 
+// DevProdHandler returns a Handler writing records of prodLvl or more
+// severe to prod, e.g. a JSON file or socket for machines to read, and,
+// when standard error is a terminal, records of devLvl or more severe to
+// it in TerminalFormat for the developer at the keyboard:
+//
+//     log.Root().SetHandler(log.DevProdHandler(log.LvlDebug, log.LvlInfo,
+//         log.Must.FileHandler("/var/log/app.json", log.JSONFormat())))
+//
+// When standard error is not a terminal, such as in a container, only
+// prod is written to.
+func DevProdHandler(devLvl, prodLvl Lvl, prod Handler) Handler {
+	h := LvlFilterHandler(prodLvl, prod)
+	if !isatty.IsTerminal(os.Stderr.Fd()) {
+		return h
+	}
+	w, fmtr, _ := terminalOutput(os.Stderr)
+	dev := StreamHandler(w, fmtr)
+	return MultiHandler(LvlFilterHandler(devLvl, dev), h)
+}
+
+// SetExitFunc replaces os.Exit as the function Crit and Critf call to end
+// the program, e.g. to test code paths that log a critical error. If fn
+// returns, so does Crit. A nil fn restores os.Exit.
+func SetExitFunc(fn func(code int)) {
+	if fn == nil {
+		fn = os.Exit
+	}
+	exitMu.Lock()
+	exitFunc = fn
+	exitMu.Unlock()
+}
+
+// OnExit registers fn to run when Crit or Critf end the program, before
+// the exit function is called, and on the crashes caught by FlushOnSignal
+// and FlushOnPanic. Hooks run in the order they were added; they
+// typically flush and close buffered handlers such as ext.Async so the
+// final records are not lost.
+func OnExit(fn func()) {
+	exitMu.Lock()
+	exitHooks = append(exitHooks, fn)
+	exitMu.Unlock()
+}
+
+// exit runs the exit hooks and then the exit function.
+func exit(code int) {
+	runExitHooks()
+	exitMu.Lock()
+	fn := exitFunc
+	exitMu.Unlock()
+	fn(code)
+}
+
+func runExitHooks() {
+	exitMu.Lock()
+	hooks := exitHooks
+	exitMu.Unlock()
+	for _, h := range hooks {
+		h()
+	}
+}
+
 // New returns a new logger with the given context.
 // New is a convenient alias for Root().New
 func New(ctx ...interface{}) Logger {
@@ -40,7 +107,52 @@ func Root() Logger {
 
 // SetLevel of the root logger
 func SetLevel(lvl Lvl) {
-	root.lvl = lvl
+	root.SetLevel(lvl)
+}
+
+// GetLevel of the root logger
+func GetLevel() Lvl {
+	return root.GetLevel()
+}
+
+// SetHandlerForLevel routes records of the root logger at lvl to h
+func SetHandlerForLevel(lvl Lvl, h Handler) {
+	root.SetHandlerForLevel(lvl, h)
+}
+
+// SetTimestamp enables or disables timestamps on records of the root logger
+func SetTimestamp(enabled bool) {
+	root.SetTimestamp(enabled)
+}
+
+// SetDevelopment enables or disables development mode on the root logger
+func SetDevelopment(enabled bool) {
+	root.SetDevelopment(enabled)
+}
+
+// SetKeyPrefix of the root logger
+func SetKeyPrefix(prefix string) {
+	root.SetKeyPrefix(prefix)
+}
+
+// SetSampler of the root logger
+func SetSampler(s Sampler) {
+	root.SetSampler(s)
+}
+
+// AddHook registers a Hook on the root logger
+func AddHook(h Hook) {
+	root.AddHook(h)
+}
+
+// EnableCaller enables caller capture on the root logger
+func EnableCaller(skip int) {
+	root.EnableCaller(skip)
+}
+
+// Subscribe to the records of the root logger and all loggers created from it
+func Subscribe(filter func(r *Record) bool, fn func(r Record)) (cancel func()) {
+	return root.Subscribe(filter, fn)
 }
 
 // The following functions bypass the exported logger methods (logger.Debug,
@@ -70,5 +182,58 @@ func Error(msg string, ctx ...interface{}) {
 // Crit is a convenient alias for Root().Crit
 func Crit(msg string, ctx ...interface{}) {
 	root.write(msg, LvlCrit, ctx)
-	os.Exit(1)
+	exit(1)
+}
+
+// Panic is a convenient alias for Root().Panic
+func Panic(msg string, ctx ...interface{}) {
+	root.write(msg, LvlCrit, ctx)
+	panic(msg)
+}
+
+// DPanic is a convenient alias for Root().DPanic
+func DPanic(msg string, ctx ...interface{}) {
+	root.write(msg, LvlCrit, ctx)
+	if root.config().dev {
+		panic(msg)
+	}
+}
+
+// Debugf is a convenient alias for Root().Debugf
+func Debugf(format string, args ...interface{}) {
+	if root.enabled(LvlDebug) {
+		msg, ctx := sprintf(format, args)
+		root.write(msg, LvlDebug, ctx)
+	}
+}
+
+// Infof is a convenient alias for Root().Infof
+func Infof(format string, args ...interface{}) {
+	if root.enabled(LvlInfo) {
+		msg, ctx := sprintf(format, args)
+		root.write(msg, LvlInfo, ctx)
+	}
+}
+
+// Warnf is a convenient alias for Root().Warnf
+func Warnf(format string, args ...interface{}) {
+	if root.enabled(LvlWarn) {
+		msg, ctx := sprintf(format, args)
+		root.write(msg, LvlWarn, ctx)
+	}
+}
+
+// Errorf is a convenient alias for Root().Errorf
+func Errorf(format string, args ...interface{}) {
+	if root.enabled(LvlError) {
+		msg, ctx := sprintf(format, args)
+		root.write(msg, LvlError, ctx)
+	}
+}
+
+// Critf is a convenient alias for Root().Critf
+func Critf(format string, args ...interface{}) {
+	msg, ctx := sprintf(format, args)
+	root.write(msg, LvlCrit, ctx)
+	exit(1)
 }
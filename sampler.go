@@ -0,0 +1,104 @@
+package log
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Sampler decides whether a record is written. A Logger consults its
+// Sampler after the level check and before the record is built, so
+// records that are sampled out cost almost nothing.
+//
+// Implementations must be safe for concurrent use.
+type Sampler interface {
+	Sample(lvl Lvl, msg string) bool
+}
+
+// SamplerFunc returns a Sampler that samples records with the given
+// function.
+func SamplerFunc(fn func(lvl Lvl, msg string) bool) Sampler {
+	return samplerFunc(fn)
+}
+
+type samplerFunc func(lvl Lvl, msg string) bool
+
+func (f samplerFunc) Sample(lvl Lvl, msg string) bool {
+	return f(lvl, msg)
+}
+
+const samplerBuckets = 4096
+
+type samplerCounter struct {
+	resetAt int64
+	count   uint64
+}
+
+// incr returns the number of records seen for the counter in the
+// current tick, including this one.
+func (c *samplerCounter) incr(now int64, tick time.Duration) uint64 {
+	resetAt := atomic.LoadInt64(&c.resetAt)
+	if resetAt > now {
+		return atomic.AddUint64(&c.count, 1)
+	}
+
+	atomic.StoreUint64(&c.count, 1)
+	newResetAt := now + int64(tick)
+	if !atomic.CompareAndSwapInt64(&c.resetAt, resetAt, newResetAt) {
+		// another goroutine reset the counter first
+		return atomic.AddUint64(&c.count, 1)
+	}
+	return 1
+}
+
+type countSampler struct {
+	tick       time.Duration
+	first      uint64
+	thereafter uint64
+	counts     [LvlDebug + 1][samplerBuckets]samplerCounter
+}
+
+// CountSampler returns a Sampler that, for every level and message, lets
+// the first records logged during each tick through and after that only
+// every thereafter-th record. For example, to log the first 100 records of
+// each message per second and then 1 in 100:
+//
+//     logger.SetSampler(log.CountSampler(time.Second, 100, 100))
+//
+// Only LvlInfo and LvlDebug records are sampled; Warn, Error and Crit
+// records always pass. Messages are bucketed by hash, so unrelated
+// messages may occasionally share a counter. A thereafter of zero drops
+// every record past the first ones in a tick.
+func CountSampler(tick time.Duration, first, thereafter int) Sampler {
+	return &countSampler{
+		tick:       tick,
+		first:      uint64(first),
+		thereafter: uint64(thereafter),
+	}
+}
+
+func (s *countSampler) Sample(lvl Lvl, msg string) bool {
+	if lvl < LvlInfo || lvl > LvlDebug {
+		return true
+	}
+
+	c := &s.counts[lvl][fnv32a(msg)%samplerBuckets]
+	n := c.incr(time.Now().UnixNano(), s.tick)
+	if n <= s.first {
+		return true
+	}
+	return s.thereafter > 0 && (n-s.first)%s.thereafter == 0
+}
+
+// fnv32a is an allocation-free FNV-1a hash of s.
+func fnv32a(s string) uint32 {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
+	h := uint32(offset32)
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= prime32
+	}
+	return h
+}
@@ -0,0 +1,69 @@
+package log
+
+// Severity is the name and number of a level in an external system.
+type Severity struct {
+	Name   string
+	Number int
+}
+
+// SeverityMap translates levels into the severities of an external
+// system. The built-in maps are used by the formats and handlers writing
+// to those systems and may be changed before logging starts, e.g. to
+// page on-call staff for critical records:
+//
+//     log.SyslogSeverities[log.LvlCrit] = log.Severity{"alert", 1}
+//
+type SeverityMap map[Lvl]Severity
+
+// Lookup returns the severity of lvl. A level missing from the map gets
+// the severity of the closest more severe level that is present.
+func (m SeverityMap) Lookup(lvl Lvl) Severity {
+	for l := lvl; l >= LvlCrit; l-- {
+		if s, ok := m[l]; ok {
+			return s
+		}
+	}
+	return m[LvlCrit]
+}
+
+// Built-in severity maps
+var (
+	// SyslogSeverities holds the syslog severities of RFC 5424, used by
+	// SyslogHandler and RFC3164Format.
+	SyslogSeverities = SeverityMap{
+		LvlCrit:  {"crit", 2},
+		LvlError: {"err", 3},
+		LvlWarn:  {"warning", 4},
+		LvlInfo:  {"info", 6},
+		LvlDebug: {"debug", 7},
+	}
+
+	// ECSSeverities holds the log.level values used by ECSFormat.
+	ECSSeverities = SeverityMap{
+		LvlCrit:  {"critical", 2},
+		LvlError: {"error", 3},
+		LvlWarn:  {"warning", 4},
+		LvlInfo:  {"info", 6},
+		LvlDebug: {"debug", 7},
+	}
+
+	// GCPSeverities holds the Cloud Logging LogSeverity values used by
+	// GCPFormat.
+	GCPSeverities = SeverityMap{
+		LvlCrit:  {"CRITICAL", 600},
+		LvlError: {"ERROR", 500},
+		LvlWarn:  {"WARNING", 400},
+		LvlInfo:  {"INFO", 200},
+		LvlDebug: {"DEBUG", 100},
+	}
+
+	// OTelSeverities holds the OpenTelemetry severity numbers and texts
+	// used when exporting records over OTLP.
+	OTelSeverities = SeverityMap{
+		LvlCrit:  {"FATAL", 21},
+		LvlError: {"ERROR", 17},
+		LvlWarn:  {"WARN", 13},
+		LvlInfo:  {"INFO", 9},
+		LvlDebug: {"DEBUG", 5},
+	}
+)
@@ -0,0 +1,172 @@
+// Package stress runs concurrent producers against a handler and checks
+// the records that come out of it, to catch the concurrency bugs that
+// buffered and networked handlers are prone to.
+//
+// Every producer logs records carrying its id and a sequence number. The
+// handler under test formats them as JSON lines into a checking writer,
+// which verifies that each line is a well-formed record and that the
+// sequence numbers of each producer only increase. Handlers that drop
+// records under load may leave gaps, but must never reorder, duplicate or
+// corrupt them.
+package stress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/semihalev/log"
+)
+
+// Config controls a stress run.
+type Config struct {
+	// Producers is the number of goroutines logging concurrently.
+	Producers int
+	// Duration is how long the producers keep logging.
+	Duration time.Duration
+	// Payload is the size in bytes of a string value added to every
+	// record.
+	Payload int
+	// Unordered skips the sequence check for handlers that may write
+	// records out of order, such as ext.AsyncHandler with the WriteSync
+	// policy.
+	Unordered bool
+}
+
+// Result summarizes a stress run.
+type Result struct {
+	// Logged is the number of records the producers logged.
+	Logged uint64
+	// Received is the number of valid records the handler wrote.
+	Received uint64
+	// MaxHeap is the largest heap size sampled during the run.
+	MaxHeap uint64
+}
+
+// Target builds the handler under test, which must write JSON lines as
+// formatted by log.JSONFormat to w. The returned function is called once
+// the producers are done and must flush the handler, so all records it
+// is going to write have reached w when it returns.
+type Target func(w io.Writer) (h log.Handler, flush func())
+
+// Run logs from cfg.Producers goroutines into the handler built by target
+// for cfg.Duration and returns the first violation found, if any.
+func Run(cfg Config, target Target) (Result, error) {
+	var res Result
+	c := &checker{last: make(map[int64]int64), unordered: cfg.Unordered}
+	h, flush := target(c)
+
+	l := log.New()
+	l.SetHandler(h)
+	l.SetLevel(log.LvlDebug)
+
+	payload := string(bytes.Repeat([]byte{'x'}, cfg.Payload))
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for p := 0; p < cfg.Producers; p++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for seq := 0; ; seq++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				l.Info("stress", "producer", id, "seq", seq, "payload", payload)
+				atomic.AddUint64(&res.Logged, 1)
+			}
+		}(p)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tick := time.NewTicker(10 * time.Millisecond)
+		defer tick.Stop()
+		var ms runtime.MemStats
+		for {
+			runtime.ReadMemStats(&ms)
+			if ms.HeapAlloc > res.MaxHeap {
+				res.MaxHeap = ms.HeapAlloc
+			}
+			select {
+			case <-stop:
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+
+	time.Sleep(cfg.Duration)
+	close(stop)
+	wg.Wait()
+	<-done
+	flush()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res.Received = c.received
+	if c.err == nil && len(c.partial) > 0 {
+		c.err = fmt.Errorf("stress: incomplete last line %q", c.partial)
+	}
+	if c.err == nil && res.Received > res.Logged {
+		c.err = fmt.Errorf("stress: received %d records, only %d logged", res.Received, res.Logged)
+	}
+	return res, c.err
+}
+
+// checker is the writer handed to the target. It verifies the records
+// written to it line by line.
+type checker struct {
+	mu        sync.Mutex
+	partial   []byte
+	last      map[int64]int64
+	unordered bool
+	received  uint64
+	err       error
+}
+
+func (c *checker) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	buf := append(c.partial, p...)
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			break
+		}
+		c.check(buf[:i])
+		buf = buf[i+1:]
+	}
+	c.partial = append([]byte(nil), buf...)
+	return len(p), nil
+}
+
+func (c *checker) check(line []byte) {
+	if c.err != nil {
+		return
+	}
+	r, err := log.ParseJSON(line)
+	if err != nil {
+		c.err = fmt.Errorf("stress: corrupt record %q: %v", line, err)
+		return
+	}
+	id, ok1 := r.LookupInt64("producer")
+	seq, ok2 := r.LookupInt64("seq")
+	if !ok1 || !ok2 || r.Msg != "stress" {
+		c.err = fmt.Errorf("stress: record with missing fields %q", line)
+		return
+	}
+	if last, ok := c.last[id]; ok && seq <= last && !c.unordered {
+		c.err = fmt.Errorf("stress: producer %d sequence went from %d to %d", id, last, seq)
+		return
+	}
+	c.last[id] = seq
+	c.received++
+}
@@ -0,0 +1,77 @@
+package stress
+
+import (
+	"flag"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/semihalev/log"
+	"github.com/semihalev/log/ext"
+)
+
+var duration = flag.Duration("stress.duration", 200*time.Millisecond, "how long each stress run lasts")
+
+const maxHeap = 512 << 20
+
+func TestStress(t *testing.T) {
+	targets := map[string]Target{
+		"stream": func(w io.Writer) (log.Handler, func()) {
+			return log.StreamHandler(w, log.JSONFormat()), func() {}
+		},
+		"async-block":       asyncTarget(ext.Block),
+		"async-drop-newest": asyncTarget(ext.DropNewest),
+		"async-drop-oldest": asyncTarget(ext.DropOldest),
+		"async-write-sync":  asyncTarget(ext.WriteSync),
+		"async-batch": func(w io.Writer) (log.Handler, func()) {
+			h := ext.AsyncBatchHandler(1024, ext.DropOldest, 64, time.Millisecond,
+				ext.BatchStreamHandler(w, log.JSONFormat()))
+			return h, h.Close
+		},
+		"reconnect-tcp": func(w io.Writer) (log.Handler, func()) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			copied := make(chan struct{})
+			go func() {
+				defer close(copied)
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				io.Copy(w, conn)
+			}()
+			h := ext.ReconnectHandler("tcp", ln.Addr().String(), 1024, log.JSONFormat())
+			return h, func() {
+				h.Close()
+				<-copied
+				ln.Close()
+			}
+		},
+	}
+
+	for name, target := range targets {
+		cfg := Config{Producers: 8, Duration: *duration, Payload: 64, Unordered: name == "async-write-sync"}
+		res, err := Run(cfg, target)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if res.Received == 0 {
+			t.Errorf("%s: no records received", name)
+		}
+		if res.MaxHeap > maxHeap {
+			t.Errorf("%s: heap grew to %d bytes", name, res.MaxHeap)
+		}
+		t.Logf("%s: logged %d, received %d, max heap %d", name, res.Logged, res.Received, res.MaxHeap)
+	}
+}
+
+func asyncTarget(policy ext.OverflowPolicy) Target {
+	return func(w io.Writer) (log.Handler, func()) {
+		h := ext.AsyncHandler(1024, policy, log.StreamHandler(w, log.JSONFormat()))
+		return h, h.Close
+	}
+}
@@ -3,8 +3,12 @@
 package log
 
 import (
+	"bytes"
+	"fmt"
 	"log/syslog"
+	"os"
 	"strings"
+	"time"
 )
 
 // SyslogHandler opens a connection to the system syslog daemon by calling
@@ -27,16 +31,22 @@ func sharedSyslog(fmtr Format, sysWr *syslog.Writer, err error) (Handler, error)
 	}
 	h := FuncHandler(func(r *Record) error {
 		var syslogFn = sysWr.Info
-		switch r.Lvl {
-		case LvlCrit:
+		switch syslog.Priority(SyslogSeverities.Lookup(r.Lvl).Number) {
+		case syslog.LOG_EMERG:
+			syslogFn = sysWr.Emerg
+		case syslog.LOG_ALERT:
+			syslogFn = sysWr.Alert
+		case syslog.LOG_CRIT:
 			syslogFn = sysWr.Crit
-		case LvlError:
+		case syslog.LOG_ERR:
 			syslogFn = sysWr.Err
-		case LvlWarn:
+		case syslog.LOG_WARNING:
 			syslogFn = sysWr.Warning
-		case LvlInfo:
+		case syslog.LOG_NOTICE:
+			syslogFn = sysWr.Notice
+		case syslog.LOG_INFO:
 			syslogFn = sysWr.Info
-		case LvlDebug:
+		case syslog.LOG_DEBUG:
 			syslogFn = sysWr.Debug
 		}
 
@@ -46,6 +56,77 @@ func sharedSyslog(fmtr Format, sysWr *syslog.Writer, err error) (Handler, error)
 	return LazyHandler(&closingHandler{sysWr, h}), nil
 }
 
+// RFC3164 configures RFC3164Format for the quirks of the receiving
+// appliance.
+type RFC3164 struct {
+	// Facility of the messages, e.g. syslog.LOG_LOCAL0. The zero value,
+	// which is the kernel facility, means syslog.LOG_USER. The severity
+	// is derived from the record's level.
+	Facility syslog.Priority
+	// Hostname is written after the timestamp. It is omitted when empty,
+	// as some older receivers expect.
+	Hostname string
+	// Tag is the program name, written before the message.
+	Tag string
+	// PID appends the process id to the tag as in "app[123]:".
+	PID bool
+	// TimeLayout overrides the timestamp layout, time.Stamp by default.
+	TimeLayout string
+	// UTC writes timestamps in UTC rather than local time.
+	UTC bool
+}
+
+// RFC3164Format formats records as legacy BSD syslog messages for
+// appliances and SIEMs that reject RFC 5424, with the message part
+// formatted by fmtr:
+//
+//     <PRI>Jan  2 15:04:05 host tag[pid]: message
+//
+// Each message ends with a newline, so it can be sent over TCP as well as
+// UDP, e.g. with NetHandler:
+//
+//     log.NetHandler("udp", "siem:514", log.RFC3164Format(log.RFC3164{
+//         Facility: syslog.LOG_LOCAL0, Hostname: "web1", Tag: "app"}, log.LogfmtFormat()))
+//
+func RFC3164Format(cfg RFC3164, fmtr Format) Format {
+	layout := cfg.TimeLayout
+	if layout == "" {
+		layout = time.Stamp
+	}
+	facility := cfg.Facility &^ 0x07
+	if facility == syslog.LOG_KERN {
+		facility = syslog.LOG_USER
+	}
+	tag := cfg.Tag
+	if cfg.PID {
+		tag = fmt.Sprintf("%s[%d]", tag, os.Getpid())
+	}
+
+	return FormatFunc(func(r *Record) []byte {
+		t := r.Time
+		if t.IsZero() {
+			t = time.Now()
+		}
+		if cfg.UTC {
+			t = t.UTC()
+		}
+
+		b := &bytes.Buffer{}
+		fmt.Fprintf(b, "<%d>%s ", facility|syslog.Priority(SyslogSeverities.Lookup(r.Lvl).Number), t.Format(layout))
+		if cfg.Hostname != "" {
+			b.WriteString(cfg.Hostname)
+			b.WriteByte(' ')
+		}
+		if tag != "" {
+			b.WriteString(tag)
+			b.WriteString(": ")
+		}
+		b.Write(bytes.TrimSpace(fmtr.Format(r)))
+		b.WriteByte('\n')
+		return b.Bytes()
+	})
+}
+
 func (m muster) SyslogHandler(priority syslog.Priority, tag string, fmtr Format) Handler {
 	return must(SyslogHandler(priority, tag, fmtr))
 }
@@ -0,0 +1,26 @@
+// +build !windows,!plan9
+
+package log
+
+import (
+	"log/syslog"
+	"testing"
+	"time"
+)
+
+func TestRFC3164Format(t *testing.T) {
+	t.Parallel()
+
+	f := RFC3164Format(RFC3164{Facility: syslog.LOG_LOCAL0, Hostname: "web1", Tag: "app", UTC: true},
+		FormatFunc(func(r *Record) []byte { return []byte(r.Msg + "\n") }))
+	r := &Record{Time: time.Date(2020, 4, 7, 15, 4, 5, 0, time.UTC), Lvl: LvlError, Msg: "disk full"}
+	if got := string(f.Format(r)); got != "<131>Apr  7 15:04:05 web1 app: disk full\n" {
+		t.Fatalf("Wrong message: %q", got)
+	}
+
+	f = RFC3164Format(RFC3164{Tag: "app", TimeLayout: "2006 Jan _2 15:04:05", UTC: true},
+		FormatFunc(func(r *Record) []byte { return []byte(r.Msg) }))
+	if got := string(f.Format(r)); got != "<11>2020 Apr  7 15:04:05 app: disk full\n" {
+		t.Fatalf("Wrong message without hostname: %q", got)
+	}
+}
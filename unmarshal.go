@@ -0,0 +1,191 @@
+package log
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	lvlType      = reflect.TypeOf(Lvl(0))
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// An UnmarshalError reports a context value that cannot be stored in the
+// struct field tagged with its key.
+type UnmarshalError struct {
+	Key   string
+	Value interface{}
+	Type  reflect.Type
+}
+
+func (e *UnmarshalError) Error() string {
+	return fmt.Sprintf("log: cannot unmarshal %T value of key %q into %s", e.Value, e.Key, e.Type)
+}
+
+// Unmarshal stores the record's context in the struct pointed to by v, so
+// tools built on Decoder get typed access to the fields they care about:
+//
+//     var req struct {
+//         UserID int64         `log:"user_id"`
+//         Took   time.Duration `log:"took"`
+//         Msg    string        `log:"msg"`
+//     }
+//     err := r.Unmarshal(&req)
+//
+// A field is filled from the context entry whose key is given by its log
+// tag, or by the field name if it has no tag; fields tagged "-" and
+// unexported fields are ignored, and so are keys without a field. The
+// record's time, level and message are available under the key names of
+// the record for fields of type time.Time, Lvl and string.
+//
+// Values are converted to the field type where that loses nothing:
+// numbers between numeric types that can hold them, strings in RFC 3339
+// format into time.Time, strings such as "1.5s" into time.Duration, and
+// strings into types implementing encoding.TextUnmarshaler. Otherwise
+// Unmarshal returns an *UnmarshalError, after filling the fields it could.
+func (r *Record) Unmarshal(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("log: Unmarshal needs a non-nil pointer to a struct")
+	}
+	rv = rv.Elem()
+
+	var first error
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		key := f.Name
+		if tag, ok := f.Tag.Lookup("log"); ok {
+			key = tag
+		}
+		if key == "-" {
+			continue
+		}
+
+		val, ok := r.field(key, f.Type)
+		if !ok || val == nil {
+			continue
+		}
+		if err := setField(rv.Field(i), val); err != nil && first == nil {
+			first = &UnmarshalError{Key: key, Value: val, Type: f.Type}
+		}
+	}
+	return first
+}
+
+// field returns the value for key, taking the record's time, level and
+// message for the key names of the record if the field type fits them.
+func (r *Record) field(key string, typ reflect.Type) (interface{}, bool) {
+	switch {
+	case key == r.KeyNames.Time && typ == timeType:
+		return r.Time, true
+	case key == r.KeyNames.Lvl && typ == lvlType:
+		return r.Lvl, true
+	case key == r.KeyNames.Msg && typ.Kind() == reflect.String:
+		return r.Msg, true
+	}
+	return r.Lookup(key)
+}
+
+func setField(fv reflect.Value, val interface{}) error {
+	v := reflect.ValueOf(val)
+	if v.Type().AssignableTo(fv.Type()) {
+		fv.Set(v)
+		return nil
+	}
+
+	if s, ok := val.(string); ok {
+		if u, ok := fv.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return u.UnmarshalText([]byte(s))
+		}
+		switch fv.Type() {
+		case timeType:
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return err
+			}
+			fv.Set(reflect.ValueOf(t))
+			return nil
+		case durationType:
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return err
+			}
+			fv.SetInt(int64(d))
+			return nil
+		}
+	}
+
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := toInt64(v)
+		if !ok || fv.OverflowInt(n) {
+			break
+		}
+		fv.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, ok := toUint64(v)
+		if !ok || fv.OverflowUint(u) {
+			break
+		}
+		fv.SetUint(u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		switch v.Kind() {
+		case reflect.Float32, reflect.Float64:
+			fv.SetFloat(v.Float())
+			return nil
+		}
+		if n, ok := toInt64(v); ok {
+			fv.SetFloat(float64(n))
+			return nil
+		}
+	case reflect.String:
+		if v.Kind() == reflect.String {
+			fv.SetString(v.String())
+			return nil
+		}
+	case reflect.Bool:
+		if v.Kind() == reflect.Bool {
+			fv.SetBool(v.Bool())
+			return nil
+		}
+	}
+	return errors.New("incompatible type")
+}
+
+// toInt64 returns the value of an integer, or of a float without a
+// fractional part.
+func toInt64(v reflect.Value) (int64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := v.Uint()
+		return int64(u), u <= 1<<63-1
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if f >= -(1<<63) && f < 1<<63 && f == float64(int64(f)) {
+			return int64(f), true
+		}
+	}
+	return 0, false
+}
+
+// toUint64 is like toInt64 for unsigned values.
+func toUint64(v reflect.Value) (uint64, bool) {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint(), true
+	}
+	n, ok := toInt64(v)
+	return uint64(n), ok && n >= 0
+}
@@ -0,0 +1,84 @@
+package log
+
+import (
+	"bytes"
+	"sync"
+)
+
+// A LineWriter is an io.Writer logging each line written to it as the
+// message of a record, for capturing output of code that only knows how
+// to write text, such as the standard output of a subprocess or the
+// ErrorLog of an http.Server:
+//
+//     cmd.Stderr = log.NewLineWriter(l.New("cmd", "backup"), log.LvlWarn)
+//
+//     srv.ErrorLog = stdlog.New(log.NewLineWriter(l, log.LvlError), "", 0)
+//
+// Lines are split on "\n" with a trailing "\r" removed; blank lines are
+// skipped. A partial line is kept until the rest of it is written, or
+// until Close is called. It is safe to write from several goroutines.
+type LineWriter struct {
+	mu  sync.Mutex
+	l   Logger
+	lvl Lvl
+	buf []byte
+}
+
+// NewLineWriter returns a LineWriter logging lines to l at lvl.
+func NewLineWriter(l Logger, lvl Lvl) *LineWriter {
+	return &LineWriter{l: l, lvl: lvl}
+}
+
+// Write logs every complete line of p. It never fails.
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n := len(p)
+	for {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			break
+		}
+		if len(w.buf) > 0 {
+			w.buf = append(w.buf, p[:i]...)
+			w.log(w.buf)
+			w.buf = w.buf[:0]
+		} else {
+			w.log(p[:i])
+		}
+		p = p[i+1:]
+	}
+	w.buf = append(w.buf, p...)
+	return n, nil
+}
+
+// Close logs the partial line written last, if any.
+func (w *LineWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.log(w.buf)
+	w.buf = w.buf[:0]
+	return nil
+}
+
+func (w *LineWriter) log(line []byte) {
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	if len(bytes.TrimSpace(line)) == 0 {
+		return
+	}
+
+	msg := string(line)
+	switch w.lvl {
+	case LvlCrit:
+		w.l.Crit(msg)
+	case LvlError:
+		w.l.Error(msg)
+	case LvlWarn:
+		w.l.Warn(msg)
+	case LvlInfo:
+		w.l.Info(msg)
+	default:
+		w.l.Debug(msg)
+	}
+}